@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// validFactorUnits are the units emission factors may be expressed in. The
+// column is VARCHAR(20), so new units must stay within that.
+var validFactorUnits = map[string]bool{
+	"kg_co2e_per_tonne_km": true,
+	"kg_co2e_per_pkm":      true,
+	"kg_co2e_per_km":       true,
+	"kg_co2e_per_kwh":      true,
+	"kg_co2e_per_liter":    true,
+	"kg_co2e_per_kg":       true,
+	"kg_co2e_per_tonne":    true,
+	"kg_co2e_per_m3":       true,
+	"kg_co2e_per_gj":       true,
+	"kg_co2e_per_mmbtu":    true,
+	"kg_co2e_per_therm":    true,
+	"kg_co2e_per_unit":     true,
+	"kg_co2e_per_mile":     true,
+}
+
+// normalizeEmissionFactor fills defaults and checks a factor before it is
+// written. It does not check for duplicates; the unique index does that.
+func normalizeEmissionFactor(factor *EmissionFactor) error {
+	factor.Activity = strings.TrimSpace(factor.Activity)
+	factor.TransportMode = strings.TrimSpace(factor.TransportMode)
+	factor.Region = strings.ToUpper(strings.TrimSpace(factor.Region))
+	factor.Version = strings.TrimSpace(factor.Version)
+
+	if factor.Region == "" {
+		factor.Region = worldRegion
+	}
+	if factor.Activity == "" {
+		return invalidField("activity", "is required")
+	}
+	if factor.Version == "" {
+		return invalidField("version", "is required")
+	}
+	if factor.Factor < 0 {
+		return invalidField("factor", "must not be negative")
+	}
+	if g := factor.Gases; g != nil && (g.CO2 < 0 || g.CH4 < 0 || g.N2O < 0) {
+		return invalidField("gases", "must not be negative")
+	}
+	if err := validateGHGScope(factor.Scope, factor.Scope3Category); err != nil {
+		return err
+	}
+	if factor.Uncertainty != nil {
+		if err := factor.Uncertainty.validate("uncertainty"); err != nil {
+			return err
+		}
+	}
+	if factor.Scope3Category != 0 {
+		factor.Scope = Scope3
+	}
+	if !validFactorUnits[factor.Unit] {
+		return invalidField("unit", "unsupported unit %q", factor.Unit)
+	}
+	if factor.ValidFrom != nil && factor.ValidTo != nil && !factor.ValidTo.After(factor.ValidFrom.Time) {
+		return invalidField("valid_to", "must be after valid_from")
+	}
+	return nil
+}
+
+func factorKey(factor EmissionFactor) string {
+	return strings.Join([]string{factor.Activity, factor.TransportMode, factor.Region, factor.Version}, "|")
+}
+
+func validationResponse(c *fiber.Ctx, err error) error {
+	return c.Status(400).JSON(fiber.Map{
+		"error":   true,
+		"message": err.Error(),
+	})
+}
+
+func duplicateFactorResponse(c *fiber.Ctx, factor EmissionFactor) error {
+	return c.Status(409).JSON(fiber.Map{
+		"error": true,
+		"message": fmt.Sprintf("An emission factor already exists for activity %q, transport_mode %q, region %q, version %q",
+			factor.Activity, factor.TransportMode, factor.Region, factor.Version),
+	})
+}
+
+// CreateEmissionFactor handles POST /admin/factors.
+func (cs *CarbonService) CreateEmissionFactor(c *fiber.Ctx) error {
+	var factor EmissionFactor
+	if err := c.BodyParser(&factor); err != nil {
+		return validationResponse(c, errors.New("Invalid request format"))
+	}
+	if err := normalizeEmissionFactor(&factor); err != nil {
+		return validationResponse(c, err)
+	}
+
+	id, err := cs.store.CreateEmissionFactor(factor)
+	if errors.Is(err, ErrDuplicateFactor) {
+		return duplicateFactorResponse(c, factor)
+	}
+	if err != nil {
+		log.Printf("Failed to create emission factor: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create emission factor")
+	}
+
+	factor.ID = id
+	return c.Status(201).JSON(factor)
+}
+
+// UpdateEmissionFactor handles PUT /admin/factors/:id and replaces the row.
+func (cs *CarbonService) UpdateEmissionFactor(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return validationResponse(c, errors.New("Invalid factor id"))
+	}
+
+	var factor EmissionFactor
+	if err := c.BodyParser(&factor); err != nil {
+		return validationResponse(c, errors.New("Invalid request format"))
+	}
+	if err := normalizeEmissionFactor(&factor); err != nil {
+		return validationResponse(c, err)
+	}
+
+	err = cs.store.UpdateEmissionFactor(id, factor)
+	if errors.Is(err, ErrDuplicateFactor) {
+		return duplicateFactorResponse(c, factor)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "Emission factor not found")
+	}
+	if err != nil {
+		log.Printf("Failed to update emission factor %d: %v", id, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to update emission factor")
+	}
+
+	factor.ID = id
+	return c.JSON(factor)
+}
+
+// RetireEmissionFactor handles POST /admin/factors/:id/retire. Retiring
+// closes the validity period instead of deleting, so stored calculations
+// keep pointing at a real row.
+func (cs *CarbonService) RetireEmissionFactor(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return validationResponse(c, errors.New("Invalid factor id"))
+	}
+
+	var body struct {
+		ValidTo *Date `json:"valid_to"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return validationResponse(c, errors.New("Invalid request format"))
+		}
+	}
+	retireAt := time.Now().UTC().Truncate(24 * time.Hour)
+	if body.ValidTo != nil {
+		retireAt = body.ValidTo.Time
+	}
+
+	factor, err := cs.store.RetireEmissionFactor(id, retireAt)
+	if errors.Is(err, ErrNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "Emission factor not found or not yet valid at the retirement date")
+	}
+	if err != nil {
+		log.Printf("Failed to retire emission factor %d: %v", id, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to retire emission factor")
+	}
+
+	return c.JSON(factor)
+}
+
+// ImportEmissionFactors handles POST /admin/factors/import. The import is
+// all-or-nothing unless ?on_conflict=skip, which skips duplicates.
+func (cs *CarbonService) ImportEmissionFactors(c *fiber.Ctx) error {
+	var factors []EmissionFactor
+	if err := c.BodyParser(&factors); err != nil {
+		return validationResponse(c, errors.New("Invalid request format, expected an array of emission factors"))
+	}
+	if len(factors) == 0 {
+		return validationResponse(c, errors.New("No emission factors to import"))
+	}
+	skipDuplicates := c.Query("on_conflict") == "skip"
+
+	result, err := cs.importEmissionFactors(factors, skipDuplicates)
+	if err != nil {
+		log.Printf("Failed to import emission factors: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to import emission factors")
+	}
+	if len(result.Errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   true,
+			"message": "Import rejected, no factors were written",
+			"errors":  result.Errors,
+		})
+	}
+
+	return c.Status(201).JSON(result)
+}
+
+// FactorImportResult summarises a bulk import.
+type FactorImportResult struct {
+	Created int                 `json:"created"`
+	Skipped int                 `json:"skipped"`
+	IDs     []int               `json:"ids"`
+	Errors  []FactorImportError `json:"errors,omitempty"`
+}
+
+// FactorImportError reports why a row of a bulk import was rejected.
+type FactorImportError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// importEmissionFactors validates every row and writes them in a single
+// transaction. Row errors roll the whole import back and are returned in the
+// result rather than as err.
+func (cs *CarbonService) importEmissionFactors(factors []EmissionFactor, skipDuplicates bool) (*FactorImportResult, error) {
+	result := &FactorImportResult{}
+
+	seen := make(map[string]int)
+	for i := range factors {
+		if err := normalizeEmissionFactor(&factors[i]); err != nil {
+			result.Errors = append(result.Errors, FactorImportError{Row: i + 1, Message: err.Error()})
+			continue
+		}
+		key := factorKey(factors[i])
+		if first, exists := seen[key]; exists && !skipDuplicates {
+			result.Errors = append(result.Errors, FactorImportError{
+				Row:     i + 1,
+				Message: fmt.Sprintf("duplicates row %d", first),
+			})
+		}
+		seen[key] = i + 1
+	}
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	return cs.store.ImportEmissionFactors(factors, skipDuplicates)
+}
+
+// ImportFactorDataset handles POST /admin/factors/import/:dataset, loading a
+// published DEFRA or EPA file sent as the raw body or a multipart "file".
+func (cs *CarbonService) ImportFactorDataset(c *fiber.Ctx) error {
+	dataset, err := lookupFactorDataset(c.Params("dataset"))
+	if err != nil {
+		return validationResponse(c, err)
+	}
+	year := c.QueryInt("year")
+
+	var body io.Reader = bytes.NewReader(c.Body())
+	if header, err := c.FormFile("file"); err == nil {
+		file, err := header.Open()
+		if err != nil {
+			return validationResponse(c, errors.New("Unable to read uploaded file"))
+		}
+		defer file.Close()
+		body = file
+	}
+
+	parsed, err := dataset.Parse(body, year)
+	if err != nil {
+		return validationResponse(c, err)
+	}
+
+	result, err := cs.importEmissionFactors(parsed.Factors, c.Query("on_conflict") == "skip")
+	if err != nil {
+		log.Printf("Failed to import %s dataset: %v", dataset.Name(), err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to import emission factors")
+	}
+	if len(result.Errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   true,
+			"message": "Import rejected, no factors were written",
+			"errors":  result.Errors,
+		})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"dataset": dataset.Name(),
+		"version": parsed.Version,
+		"year":    parsed.Year,
+		"created": result.Created,
+		"skipped": result.Skipped,
+		"ignored": parsed.Skipped,
+	})
+}
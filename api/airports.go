@@ -0,0 +1,41 @@
+package main
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed data/airports.csv
+var airportsCSV string
+
+// Airport is an entry of the embedded IATA airport gazetteer.
+type Airport struct {
+	IATA     string      `json:"iata"`
+	Name     string      `json:"name"`
+	City     string      `json:"city"`
+	Country  string      `json:"country"`
+	Location Coordinates `json:"location"`
+}
+
+var airports = mustLoadAirports(airportsCSV)
+
+// lookupAirport resolves a three-letter IATA code, case-insensitively.
+func lookupAirport(code string) (Airport, bool) {
+	airport, exists := airports[strings.ToUpper(strings.TrimSpace(code))]
+	return airport, exists
+}
+
+func mustLoadAirports(data string) map[string]Airport {
+	records := mustReadEmbeddedCSV("airport", data)
+	result := make(map[string]Airport, len(records))
+	for _, record := range records {
+		result[record[0]] = Airport{
+			IATA:     record[0],
+			Name:     record[1],
+			City:     record[2],
+			Country:  record[3],
+			Location: mustParseCoordinates("airport "+record[0], record[4], record[5]),
+		}
+	}
+	return result
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Scopes granted to API keys and JWTs.
+const (
+	ScopeCalculate   = "calculate"
+	ScopeReadFactors = "read-factors"
+	ScopeAdmin       = "admin"
+	ScopeAnalytics   = "analytics"
+)
+
+var knownScopes = []string{ScopeCalculate, ScopeReadFactors, ScopeAdmin, ScopeAnalytics}
+
+// apiKeyPrefix marks a credential as an API key rather than a JWT. Keys look
+// like capi_<prefix>_<secret>; the prefix is stored in clear for lookup.
+const apiKeyPrefix = "capi_"
+
+// APIKey is an issued key. The secret itself is never stored.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	OrgID      string     `json:"org_id,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Plan       string     `json:"plan"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	Salt []byte `json:"-"`
+	Hash []byte `json:"-"`
+}
+
+func (k APIKey) principal() Principal {
+	return Principal{
+		Subject:  "api_key:" + k.Prefix,
+		Org:      k.OrgID,
+		Scopes:   k.Scopes,
+		Plan:     k.Plan,
+		APIKeyID: k.ID,
+	}
+}
+
+func hashAPIKeySecret(salt []byte, secret string) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), secret...))
+	return sum[:]
+}
+
+// generateAPIKey fills in the prefix, salt and hash of key and returns the
+// full key, which is shown to the caller once.
+func generateAPIKey(key *APIKey) (string, error) {
+	prefix := make([]byte, 6)
+	secret := make([]byte, 32)
+	key.Salt = make([]byte, 16)
+	for _, buf := range [][]byte{prefix, secret, key.Salt} {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+	}
+
+	key.Prefix = hex.EncodeToString(prefix)
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hashAPIKeySecret(key.Salt, encoded)
+	return apiKeyPrefix + key.Prefix + "_" + encoded, nil
+}
+
+var (
+	errInvalidAPIKey = errors.New("Invalid API key")
+	errRevokedAPIKey = errors.New("API key has been revoked")
+)
+
+// verifyAPIKey resolves a presented key to its stored record.
+func (cs *CarbonService) verifyAPIKey(raw string) (APIKey, error) {
+	rest, ok := strings.CutPrefix(raw, apiKeyPrefix)
+	if !ok {
+		return APIKey{}, errInvalidAPIKey
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return APIKey{}, errInvalidAPIKey
+	}
+
+	key, err := cs.store.FindAPIKey(prefix)
+	if errors.Is(err, ErrNotFound) {
+		return APIKey{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare(hashAPIKeySecret(key.Salt, secret), key.Hash) != 1 {
+		return APIKey{}, errInvalidAPIKey
+	}
+	if key.RevokedAt != nil {
+		return APIKey{}, errRevokedAPIKey
+	}
+	return key, nil
+}
+
+// requireScope rejects callers that were not granted scope.
+func requireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !principalFrom(c).HasScope(scope) {
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("Missing required scope %q", scope))
+		}
+		return c.Next()
+	}
+}
+
+// CreateAPIKey handles POST /admin/api-keys. The response is the only time
+// the full key is returned.
+func (cs *CarbonService) CreateAPIKey(c *fiber.Ctx) error {
+	var body struct {
+		Name   string   `json:"name"`
+		OrgID  string   `json:"org_id"`
+		Scopes []string `json:"scopes"`
+		Plan   string   `json:"plan"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return validationResponse(c, errors.New("Invalid request format"))
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		return validationResponse(c, invalidField("name", "is required"))
+	}
+	if len(body.Scopes) == 0 {
+		return validationResponse(c, invalidField("scopes", "at least one of %s is required", strings.Join(knownScopes, ", ")))
+	}
+	for _, scope := range body.Scopes {
+		if !containsString(knownScopes, scope) {
+			return validationResponse(c, invalidField("scopes", "unknown scope %q", scope))
+		}
+	}
+
+	if body.Plan == "" {
+		body.Plan = defaultPlan
+	}
+	if _, ok := cs.limiter.plans[body.Plan]; !ok {
+		return validationResponse(c, invalidField("plan", "unknown plan %q", body.Plan))
+	}
+
+	creator := principalFrom(c)
+	key := APIKey{
+		Name:      body.Name,
+		OrgID:     body.OrgID,
+		Scopes:    body.Scopes,
+		Plan:      body.Plan,
+		CreatedBy: creator.Subject,
+	}
+	if key.OrgID == "" {
+		key.OrgID = creator.Org
+	}
+	secret, err := generateAPIKey(&key)
+	if err != nil {
+		log.Printf("Failed to generate API key: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create API key")
+	}
+
+	key, err = cs.store.CreateAPIKey(key)
+	if err != nil {
+		log.Printf("Failed to store API key: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create API key")
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"api_key": key,
+		"key":     secret,
+	})
+}
+
+// ListAPIKeys handles GET /admin/api-keys.
+func (cs *CarbonService) ListAPIKeys(c *fiber.Ctx) error {
+	keys, err := cs.store.ListAPIKeys()
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to list API keys")
+	}
+
+	return c.JSON(fiber.Map{
+		"api_keys": keys,
+		"total":    len(keys),
+	})
+}
+
+// RevokeAPIKey handles DELETE /admin/api-keys/:id. Revoked keys are kept so
+// that usage history still resolves.
+func (cs *CarbonService) RevokeAPIKey(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return validationResponse(c, errors.New("Invalid API key id"))
+	}
+
+	key, err := cs.store.RevokeAPIKey(id, time.Now().UTC())
+	if errors.Is(err, ErrNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "API key not found or already revoked")
+	}
+	if err != nil {
+		log.Printf("Failed to revoke API key %d: %v", id, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to revoke API key")
+	}
+
+	return c.JSON(key)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string   `json:"subject"`
+	Org     string   `json:"org,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+	// Plan selects the caller's rate limits and quotas.
+	Plan string `json:"plan,omitempty"`
+	// APIKeyID is set when the caller authenticated with an API key.
+	APIKeyID int `json:"-"`
+}
+
+// anonymousPrincipal is used when AUTH_DISABLED=true and the request
+// carries no credentials. It cannot reach admin routes.
+var anonymousPrincipal = Principal{
+	Subject: "anonymous",
+	Scopes:  []string{ScopeCalculate, ScopeReadFactors, ScopeAnalytics},
+	Plan:    defaultPlan,
+}
+
+// adminTokenPrincipal is the caller presenting ADMIN_API_TOKEN, which is
+// mainly useful to issue the first API keys.
+var adminTokenPrincipal = Principal{Subject: "admin-token", Scopes: knownScopes, Plan: unlimitedPlan}
+
+const principalKey = "principal"
+
+// principalFrom returns the caller stored by the auth middleware.
+func principalFrom(c *fiber.Ctx) Principal {
+	if principal, ok := c.Locals(principalKey).(Principal); ok {
+		return principal
+	}
+	return Principal{Subject: "anonymous"}
+}
+
+// HasScope reports whether the caller was granted scope.
+func (p Principal) HasScope(scope string) bool {
+	return containsString(p.Scopes, scope)
+}
+
+// authClaims are the JWT claims we read. Scopes may arrive as an OAuth
+// space-separated "scope" string or a "scopes" array.
+type authClaims struct {
+	Org    string   `json:"org,omitempty"`
+	Plan   string   `json:"plan,omitempty"`
+	Scope  string   `json:"scope,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (claims authClaims) principal() Principal {
+	scopes := append([]string{}, claims.Scopes...)
+	for _, scope := range strings.Fields(claims.Scope) {
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return Principal{Subject: claims.Subject, Org: claims.Org, Scopes: scopes, Plan: claims.Plan}
+}
+
+// AuthConfig configures request authentication. HS256 JWTs are enabled by
+// Secret and RS256 by a JWKS file or URL; both may be enabled at once.
+// API keys are always accepted.
+type AuthConfig struct {
+	Secret     []byte
+	JWKSFile   string
+	JWKSURL    string
+	Issuer     string
+	Audience   string
+	AdminToken string
+	// AllowAnonymous lets requests without credentials in as the anonymous
+	// principal; otherwise they are rejected. Meant for local development.
+	AllowAnonymous bool
+	// Next skips authentication for a request when it returns true.
+	Next func(c *fiber.Ctx) bool
+}
+
+func authConfigFromEnv() AuthConfig {
+	return AuthConfig{
+		Secret:     []byte(os.Getenv("JWT_SECRET")),
+		JWKSFile:   os.Getenv("JWT_JWKS_FILE"),
+		JWKSURL:    os.Getenv("JWT_JWKS_URL"),
+		Issuer:     os.Getenv("JWT_ISSUER"),
+		Audience:   os.Getenv("JWT_AUDIENCE"),
+		AdminToken: os.Getenv("ADMIN_API_TOKEN"),
+
+		AllowAnonymous: os.Getenv("AUTH_DISABLED") == "true",
+	}
+}
+
+// newJWTParser builds the token parser and key lookup for config, or returns
+// a nil parser when no signing key is configured.
+func newJWTParser(config AuthConfig) (*jwt.Parser, jwt.Keyfunc) {
+	var methods []string
+	if len(config.Secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	var keys *jwksKeySet
+	if config.JWKSFile != "" || config.JWKSURL != "" {
+		keys = &jwksKeySet{file: config.JWKSFile, url: config.JWKSURL}
+		if err := keys.refresh(); err != nil {
+			if config.JWKSURL == "" {
+				log.Fatalf("Failed to load JWKS: %v", err)
+			}
+			log.Printf("JWKS warning: %v (retrying on first request)", err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	if len(methods) == 0 {
+		return nil, nil
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+			return config.Secret, nil
+		}
+		kid, _ := token.Header["kid"].(string)
+		return keys.key(kid)
+	}
+	return jwt.NewParser(options...), keyFunc
+}
+
+// requireAuth identifies the caller from an API key (X-API-Key or a capi_
+// bearer token), the admin token or a JWT, and stores its Principal in the
+// request locals. Requests without credentials are rejected unless
+// anonymous access was enabled explicitly.
+func (cs *CarbonService) requireAuth(config AuthConfig) fiber.Handler {
+	parser, keyFunc := newJWTParser(config)
+	if parser == nil {
+		log.Println("JWT authentication disabled: set JWT_SECRET, JWT_JWKS_FILE or JWT_JWKS_URL; only API keys are accepted")
+	}
+	if config.AllowAnonymous {
+		log.Println("⚠️  AUTH_DISABLED=true: requests without credentials run as the anonymous principal")
+	}
+
+	return func(c *fiber.Ctx) error {
+		if config.Next != nil && config.Next(c) {
+			return c.Next()
+		}
+
+		apiKey := c.Get("X-API-Key")
+		bearer, hasBearer := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if apiKey == "" && strings.HasPrefix(bearer, apiKeyPrefix) {
+			apiKey, hasBearer = bearer, false
+		}
+
+		var principal Principal
+		switch {
+		case apiKey != "":
+			key, err := cs.verifyAPIKey(apiKey)
+			if errors.Is(err, errInvalidAPIKey) || errors.Is(err, errRevokedAPIKey) {
+				return unauthorized(c, err.Error())
+			}
+			if err != nil {
+				log.Printf("API key lookup failed: %v", err)
+				return fiber.NewError(fiber.StatusServiceUnavailable, "Unable to verify API key")
+			}
+			principal = key.principal()
+
+		case hasBearer && config.AdminToken != "" &&
+			subtle.ConstantTimeCompare([]byte(bearer), []byte(config.AdminToken)) == 1:
+			principal = adminTokenPrincipal
+
+		case hasBearer && parser != nil:
+			var claims authClaims
+			if _, err := parser.ParseWithClaims(bearer, &claims, keyFunc); err != nil {
+				return unauthorized(c, "Invalid token: "+err.Error())
+			}
+			if claims.Subject == "" {
+				return unauthorized(c, "Invalid token: missing subject")
+			}
+			principal = claims.principal()
+
+		case hasBearer:
+			return unauthorized(c, "Invalid bearer token")
+
+		case config.AllowAnonymous:
+			principal = anonymousPrincipal
+
+		default:
+			return unauthorized(c, "Missing bearer token or API key")
+		}
+
+		c.Locals(principalKey, principal)
+		return c.Next()
+	}
+}
+
+func unauthorized(c *fiber.Ctx, message string) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="carbonapi"`)
+	return fiber.NewError(fiber.StatusUnauthorized, message)
+}
+
+// jwksRefreshInterval is how long JWKS keys fetched from a URL are trusted
+// before being fetched again. Unknown key IDs trigger an earlier refresh,
+// at most once per jwksMinRefresh.
+const (
+	jwksRefreshInterval = time.Hour
+	jwksMinRefresh      = time.Minute
+)
+
+// jwksKeySet holds the RSA keys of a JSON Web Key Set.
+type jwksKeySet struct {
+	file string
+	url  string
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func (s *jwksKeySet) key(kid string) (*rsa.PublicKey, error) {
+	s.mu.RLock()
+	key, found := s.lookup(kid)
+	stale := time.Since(s.fetchedAt) > jwksRefreshInterval
+	canRefresh := s.url != "" && time.Since(s.fetchedAt) > jwksMinRefresh
+	s.mu.RUnlock()
+
+	if (found && !stale) || !canRefresh {
+		if !found {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+
+	if err := s.refresh(); err != nil {
+		log.Printf("JWKS refresh failed: %v", err)
+		if found {
+			return key, nil
+		}
+		return nil, errors.New("signing keys unavailable")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, found := s.lookup(kid); found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds a key by ID; tokens without a kid match a single-key set.
+func (s *jwksKeySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, found := s.keys[kid]
+	return key, found
+}
+
+func (s *jwksKeySet) refresh() error {
+	var data []byte
+	var err error
+	if s.url != "" {
+		data, err = fetchJWKS(s.url)
+	} else {
+		data, err = os.ReadFile(s.file)
+	}
+
+	// Record the attempt even on failure so a broken endpoint is not hammered
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+func fetchJWKS(url string) ([]byte, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseJWKS extracts the RSA signing keys of a key set, ignoring others.
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") || (jwk.Alg != "" && jwk.Alg != "RS256") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %q: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no RSA signing keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRequireAuthAndScope(t *testing.T) {
+	secret := []byte("test-secret")
+	cs := NewCarbonService(NewMemoryStore(), nil)
+
+	issued := APIKey{Name: "ci", Scopes: []string{ScopeReadFactors}, Plan: defaultPlan}
+	apiKey, err := generateAPIKey(&issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.store.CreateAPIKey(issued); err != nil {
+		t.Fatal(err)
+	}
+
+	token := func(expires time.Duration, scope string, key []byte) string {
+		claims := authClaims{
+			Scope: scope,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "user-1",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(expires)),
+			},
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+
+	tests := []struct {
+		name      string
+		anonymous bool
+		jwt       bool
+		header    string
+		value     string
+		path      string
+		want      int
+	}{
+		{name: "no credentials", path: "/calculate", want: 401},
+		{name: "no credentials, JWT enabled", jwt: true, path: "/calculate", want: 401},
+		{name: "anonymous enabled", anonymous: true, path: "/calculate", want: 200},
+		{name: "anonymous cannot reach admin", anonymous: true, path: "/admin", want: 403},
+		{name: "admin token", header: "Authorization", value: "Bearer admin", path: "/admin", want: 200},
+		{name: "wrong admin token", header: "Authorization", value: "Bearer nope", path: "/admin", want: 401},
+		{name: "bearer without JWT keys", anonymous: true, header: "Authorization", value: token(time.Hour, ScopeCalculate, secret), path: "/calculate", want: 401},
+		{name: "JWT with scope", jwt: true, header: "Authorization", value: token(time.Hour, ScopeCalculate, secret), path: "/calculate", want: 200},
+		{name: "JWT without scope", jwt: true, header: "Authorization", value: token(time.Hour, ScopeCalculate, secret), path: "/admin", want: 403},
+		{name: "expired JWT", jwt: true, header: "Authorization", value: token(-time.Hour, ScopeCalculate, secret), path: "/calculate", want: 401},
+		{name: "JWT signed with another key", jwt: true, header: "Authorization", value: token(time.Hour, ScopeCalculate, []byte("other")), path: "/calculate", want: 401},
+		{name: "API key header", header: "X-API-Key", value: apiKey, path: "/factors", want: 200},
+		{name: "API key as bearer", header: "Authorization", value: "Bearer " + apiKey, path: "/factors", want: 200},
+		{name: "API key without scope", header: "X-API-Key", value: apiKey, path: "/calculate", want: 403},
+		{name: "unknown API key", header: "X-API-Key", value: "capi_000000000000_secret", path: "/factors", want: 401},
+	}
+
+	for _, tt := range tests {
+		config := AuthConfig{AdminToken: "admin", AllowAnonymous: tt.anonymous}
+		if tt.jwt {
+			config.Secret = secret
+		}
+		app := fiber.New()
+		app.Use(cs.requireAuth(config))
+		ok := func(c *fiber.Ctx) error { return c.SendStatus(200) }
+		app.Get("/calculate", requireScope(ScopeCalculate), ok)
+		app.Get("/factors", requireScope(ScopeReadFactors), ok)
+		app.Get("/admin", requireScope(ScopeAdmin), ok)
+
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxBatchItems = 1000
+	// batchWorkers bounds how many items of one batch are computed at once.
+	batchWorkers = 8
+)
+
+// BatchItem is one line of a batch request. LineID is chosen by the client
+// to match results back to its own records; it defaults to the 1-based
+// position in the batch.
+type BatchItem struct {
+	LineID string `json:"line_id"`
+	CalculateRequest
+}
+
+// BatchItemResult is the outcome of one line. Exactly one of Result and
+// Error is set.
+type BatchItemResult struct {
+	LineID string             `json:"line_id"`
+	Status string             `json:"status"`
+	Result *CalculateResponse `json:"result,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+type BatchSummary struct {
+	TotalItems           int     `json:"total_items"`
+	Succeeded            int     `json:"succeeded"`
+	Failed               int     `json:"failed"`
+	TotalCarbonFootprint float64 `json:"total_carbon_footprint"`
+	Unit                 string  `json:"unit"`
+}
+
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+	Summary BatchSummary      `json:"summary"`
+}
+
+// CalculateBatch handles POST /calculate/batch. The body is a JSON array of
+// calculate requests. Items fail independently; the response is 200 as long
+// as the batch itself is well formed. Every item is charged against the
+// quota and failed items are refunded, as upload rows are.
+func (cs *CarbonService) CalculateBatch(c *fiber.Ctx) error {
+	var items []BatchItem
+	if err := json.Unmarshal(c.Body(), &items); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request format: expected a JSON array of calculations",
+		})
+	}
+	if len(items) == 0 {
+		return validationResponse(c, errors.New("Batch must contain at least one item"))
+	}
+	if len(items) > maxBatchItems {
+		return validationResponse(c, fmt.Errorf("Batch exceeds the limit of %d items", maxBatchItems))
+	}
+
+	seen := make(map[string]bool, len(items))
+	for i := range items {
+		if items[i].LineID == "" {
+			items[i].LineID = strconv.Itoa(i + 1)
+		}
+		if seen[items[i].LineID] {
+			return validationResponse(c, invalidField("line_id", "duplicate line id %q", items[i].LineID))
+		}
+		seen[items[i].LineID] = true
+	}
+
+	refund, err := cs.limiter.charge(c, len(items))
+	if err != nil {
+		return err
+	}
+	results := cs.calculateBatch(items, c.QueryBool("uncertainty"))
+
+	response := BatchResponse{
+		Results: results,
+		Summary: BatchSummary{TotalItems: len(items), Unit: "kg_co2e"},
+	}
+	var records []CalculationRecord
+	principal := principalFrom(c)
+	for i, result := range results {
+		if result.Result == nil {
+			response.Summary.Failed++
+			continue
+		}
+		response.Summary.Succeeded++
+		response.Summary.TotalCarbonFootprint += result.Result.CarbonFootprint
+		records = append(records, newCalculationRecord(items[i].CalculateRequest, result.Result, principal))
+	}
+	response.Summary.TotalCarbonFootprint = math.Round(response.Summary.TotalCarbonFootprint*1000) / 1000
+	if response.Summary.Failed > 0 {
+		refund(response.Summary.Failed)
+	}
+
+	if len(records) > 0 {
+		if err := cs.persistCalculations(c, records); err != nil {
+			refund(len(records))
+			return err
+		}
+	}
+
+	return c.JSON(response)
+}
+
+// calculateBatch computes items concurrently, returning results in input
+// order.
+func (cs *CarbonService) calculateBatch(items []BatchItem, withUncertainty bool) []BatchItemResult {
+	results := make([]BatchItemResult, len(items))
+	sem := make(chan struct{}, batchWorkers)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item BatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = cs.calculateBatchItem(item, withUncertainty)
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
+
+func (cs *CarbonService) calculateBatchItem(item BatchItem, withUncertainty bool) BatchItemResult {
+	result := BatchItemResult{LineID: item.LineID, Status: "error"}
+	if item.Activity == "" {
+		result.Error = "Activity is required"
+		return result
+	}
+
+	calculation, err := cs.calculateCarbonFootprint(item.CalculateRequest, withUncertainty)
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		result.Error = validationErr.Error()
+	case err != nil:
+		log.Printf("Calculation error for batch line %s: %v", item.LineID, err)
+		result.Error = "Failed to calculate carbon footprint"
+	default:
+		result.Status = "ok"
+		result.Result = calculation
+	}
+	return result
+}
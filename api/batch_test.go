@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCalculateBatchLineIDs(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	app := fiber.New()
+	app.Post("/calculate/batch", cs.CalculateBatch)
+
+	fuel := `{"activity":"fuel","amount":10,"transport":"diesel"`
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		lines  []string
+		failed int
+	}{
+		{"default ids", "[" + fuel + "}," + fuel + "}]", 200, []string{"1", "2"}, 0},
+		{"client ids", "[" + fuel + `,"line_id":"a"},` + fuel + `,"line_id":"b"}]`, 200, []string{"a", "b"}, 0},
+		{"failing line", "[" + fuel + `,"line_id":"a"},{"activity":"fuel","line_id":"b"}]`, 200, []string{"a", "b"}, 1},
+		{"duplicate client ids", "[" + fuel + `,"line_id":"a"},` + fuel + `,"line_id":"a"}]`, 400, nil, 0},
+		{"client id clashing with a default", "[" + fuel + "}," + fuel + `,"line_id":"1"}]`, 400, nil, 0},
+		{"empty", "[]", 400, nil, 0},
+		{"not an array", fuel + "}", 400, nil, 0},
+		{"too many lines", "[" + strings.Repeat(fuel+"},", maxBatchItems) + fuel + "}]", 400, nil, 0},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/calculate/batch", strings.NewReader(tt.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.status)
+			continue
+		}
+		if tt.status != 200 {
+			continue
+		}
+
+		var batch BatchResponse
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for i, result := range batch.Results {
+			if result.LineID != tt.lines[i] {
+				t.Errorf("%s: result %d is line %q, want %q", tt.name, i, result.LineID, tt.lines[i])
+			}
+		}
+		if batch.Summary.Failed != tt.failed || batch.Summary.Succeeded != len(tt.lines)-tt.failed {
+			t.Errorf("%s: %d succeeded, %d failed", tt.name, batch.Summary.Succeeded, batch.Summary.Failed)
+		}
+	}
+}
+
+func TestCalculateBatchRefundsFailedLines(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	app := fiber.New()
+	app.Use(cs.requireAuth(AuthConfig{AllowAnonymous: true}))
+	app.Post("/calculate/batch", cs.CalculateBatch)
+
+	fuel := `{"activity":"fuel","amount":10,"transport":"diesel"}`
+	tests := []struct {
+		body      string
+		remaining string
+	}{
+		// Both lines are charged up front; the failed one is refunded after
+		{"[" + fuel + `,{"activity":"fuel"}]`, "998"},
+		{`[{"activity":"fuel"},{"activity":"fuel"}]`, "997"},
+		{"[" + fuel + "]", "998"},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("POST", "/calculate/batch", strings.NewReader(tt.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining := resp.Header.Get("X-Quota-Remaining"); resp.StatusCode != 200 || remaining != tt.remaining {
+			t.Errorf("batch %d: status %d, %s remaining; want 200, %s", i, resp.StatusCode, remaining, tt.remaining)
+		}
+	}
+}
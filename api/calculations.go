@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	maxTags                 = 20
+	maxTagLength            = 50
+	defaultCalculationLimit = 50
+	maxCalculationLimit     = 200
+)
+
+func validateTags(tags []string) error {
+	if len(tags) > maxTags {
+		return invalidField("tags", "at most %d tags are allowed", maxTags)
+	}
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" || len(tag) > maxTagLength {
+			return invalidField("tags", "each tag must be 1 to %d characters", maxTagLength)
+		}
+	}
+	return nil
+}
+
+// calculationVisibility restricts filter to what the caller may read: its
+// org's calculations, or its own when it has no org. Admins read everything.
+func calculationVisibility(principal Principal, filter *CalculationFilter) {
+	switch {
+	case principal.HasScope(ScopeAdmin):
+	case principal.Org != "":
+		filter.OrgID = principal.Org
+	default:
+		filter.UserID = principal.Subject
+	}
+}
+
+func canReadCalculation(principal Principal, record CalculationRecord) bool {
+	var filter CalculationFilter
+	calculationVisibility(principal, &filter)
+	return (filter.OrgID == "" || record.OrgID == filter.OrgID) &&
+		(filter.UserID == "" || record.UserID == filter.UserID)
+}
+
+// GetCalculation handles GET /calculations/:id, where id is the
+// calculation_id returned by /calculate or the numeric record ID.
+func (cs *CarbonService) GetCalculation(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var record CalculationRecord
+	var err error
+	if number, convErr := strconv.Atoi(id); convErr == nil {
+		record, err = cs.store.GetCalculation(number)
+	} else if _, parseErr := uuid.Parse(id); parseErr == nil {
+		record, err = cs.store.FindCalculation(id)
+	} else {
+		return validationResponse(c, errors.New("Invalid calculation id"))
+	}
+
+	if errors.Is(err, ErrNotFound) || err == nil && !canReadCalculation(principalFrom(c), record) {
+		return fiber.NewError(fiber.StatusNotFound, "Calculation not found")
+	}
+	if err != nil {
+		log.Printf("Failed to load calculation %s: %v", id, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to load calculation")
+	}
+
+	return c.JSON(record)
+}
+
+// ListCalculations handles GET /calculations. Filters: activity, user_id,
+// tag, scope, from/to (dates or RFC 3339 times), min_footprint/max_footprint.
+// sort is created_at or carbon_footprint, descending with a "-" prefix
+// (default -created_at). Pages continue from next_cursor.
+func (cs *CarbonService) ListCalculations(c *fiber.Ctx) error {
+	filter, err := calculationFilterFromQuery(c)
+	if err != nil {
+		return validationResponse(c, err)
+	}
+	principal := principalFrom(c)
+	if filter.UserID != "" && !principal.HasScope(ScopeAdmin) && principal.Org == "" && filter.UserID != principal.Subject {
+		return fiber.NewError(fiber.StatusForbidden, "Cannot list calculations of other users")
+	}
+	calculationVisibility(principal, &filter)
+
+	// Fetch one extra record to learn whether there is another page
+	limit := filter.Limit
+	filter.Limit++
+	records, err := cs.store.ListCalculations(filter)
+	if err != nil {
+		log.Printf("Failed to list calculations: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to list calculations")
+	}
+
+	var nextCursor string
+	if len(records) > limit {
+		records = records[:limit]
+		last := records[len(records)-1]
+		nextCursor = encodeCalculationCursor(CalculationCursor{
+			Sort:            filter.Sort,
+			CreatedAt:       last.CreatedAt,
+			CarbonFootprint: last.CarbonFootprint,
+			ID:              last.ID,
+		})
+	}
+	if records == nil {
+		records = []CalculationRecord{}
+	}
+
+	return c.JSON(fiber.Map{
+		"calculations": records,
+		"count":        len(records),
+		"next_cursor":  nextCursor,
+		"has_more":     nextCursor != "",
+	})
+}
+
+func calculationFilterFromQuery(c *fiber.Ctx) (CalculationFilter, error) {
+	filter := CalculationFilter{
+		Activity: c.Query("activity"),
+		UserID:   c.Query("user_id"),
+		Tag:      c.Query("tag"),
+		Scope:    c.QueryInt("scope"),
+		Limit:    c.QueryInt("limit", defaultCalculationLimit),
+	}
+	if filter.Scope < 0 || filter.Scope > Scope3 {
+		return filter, invalidField("scope", "must be 1, 2 or 3")
+	}
+	if filter.Limit < 1 || filter.Limit > maxCalculationLimit {
+		return filter, invalidField("limit", "must be between 1 and %d", maxCalculationLimit)
+	}
+
+	var err error
+	if filter.From, err = parseTimeQuery(c.Query("from"), false); err != nil {
+		return filter, invalidField("from", "%v", err)
+	}
+	if filter.To, err = parseTimeQuery(c.Query("to"), true); err != nil {
+		return filter, invalidField("to", "%v", err)
+	}
+	if filter.MinFootprint, err = parseFloatQuery(c.Query("min_footprint")); err != nil {
+		return filter, invalidField("min_footprint", "must be a number")
+	}
+	if filter.MaxFootprint, err = parseFloatQuery(c.Query("max_footprint")); err != nil {
+		return filter, invalidField("max_footprint", "must be a number")
+	}
+
+	sortBy := c.Query("sort", "-"+SortCreatedAt)
+	filter.Sort = strings.TrimPrefix(sortBy, "-")
+	filter.Descending = strings.HasPrefix(sortBy, "-")
+	if filter.Sort != SortCreatedAt && filter.Sort != SortCarbonFootprint {
+		return filter, invalidField("sort", "must be created_at or carbon_footprint, optionally prefixed with -")
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodeCalculationCursor(raw)
+		if err != nil || cursor.Sort != filter.Sort {
+			return filter, invalidField("cursor", "is invalid for this sort order")
+		}
+		filter.After = &cursor
+	}
+	return filter, nil
+}
+
+// parseTimeQuery accepts a date or an RFC 3339 time. A date used as the end
+// of a range includes that whole day.
+func parseTimeQuery(value string, endOfRange bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.New("must be a date (YYYY-MM-DD) or RFC 3339 time")
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func parseFloatQuery(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &number, nil
+}
+
+func encodeCalculationCursor(cursor CalculationCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCalculationCursor(raw string) (CalculationCursor, error) {
+	var cursor CalculationCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	return cursor, err
+}
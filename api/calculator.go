@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Calculator computes the footprint for a single activity type. Each activity
+// lives in its own file and registers itself from init, so adding a new one
+// never touches the request handler.
+type Calculator interface {
+	// Activity is the CalculateRequest.Activity value this calculator handles.
+	Activity() string
+	// Describe returns the catalogue entry served by GET /activities.
+	Describe() ActivityDescription
+	// RequiredFields lists the request fields a caller must supply.
+	RequiredFields() []string
+	// Formula is a human-readable description of the calculation.
+	Formula() string
+	// Validate rejects malformed input before any factor lookup happens.
+	Validate(req CalculateRequest) error
+	// Calculate returns the footprint in kg CO2e and its breakdown.
+	Calculate(factors FactorSource, req CalculateRequest) (CalculatorResult, error)
+}
+
+// Suggester is implemented by calculators that can offer reduction tips.
+type Suggester interface {
+	Suggestions(req CalculateRequest, carbonFootprint float64) []string
+}
+
+// ActivityDescription is the public description of a registered calculator.
+type ActivityDescription struct {
+	Description string
+	OptionsKey  string // e.g. "transport_modes", "fuel_types"
+	Options     []string
+	Example     map[string]interface{}
+}
+
+// CalculatorResult is what a Calculator hands back to the service.
+type CalculatorResult struct {
+	CarbonFootprint float64
+	Breakdown       map[string]interface{}
+	// Gases is the mass of each gas emitted, nil unless every factor used
+	// has a gas split.
+	Gases *GasAmounts
+	// Scope2 is set by calculators of purchased energy.
+	Scope2 *Scope2Result
+}
+
+// FactorSource resolves emission factors for calculators.
+type FactorSource interface {
+	EmissionFactor(q FactorQuery) (EmissionFactor, error)
+}
+
+// IntensitySource is implemented by factor sources that also serve hourly
+// grid intensity.
+type IntensitySource interface {
+	HourlyIntensity(region string, from, to time.Time) (IntensitySeries, error)
+}
+
+// EstimateSampler is implemented by factor sources that propagate
+// uncertainty. Calculators pass inputs they estimated, such as distances
+// from coordinates, through sampleEstimate so each run can vary them.
+type EstimateSampler interface {
+	SampleEstimate(name string, value float64, u Uncertainty) float64
+}
+
+// sampleEstimate returns value, or a draw from its range when factors is
+// sampling. Inputs without a range (u nil) are taken as exact.
+func sampleEstimate(factors FactorSource, name string, value float64, u *Uncertainty) float64 {
+	if sampler, ok := factors.(EstimateSampler); ok && u != nil {
+		return sampler.SampleEstimate(name, value, *u)
+	}
+	return value
+}
+
+// FactorQuery identifies the emission factor a calculator needs.
+type FactorQuery struct {
+	Activity string
+	Mode     string
+	Region   string    // empty means WORLD
+	At       time.Time // zero means the request's activity date
+	// Optional lookups return ErrNotFound instead of a built-in default.
+	Optional bool
+}
+
+// ValidationError reports a problem with the caller's input.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func invalidField(field, format string, args ...interface{}) error {
+	return &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// renameField points a validation error at the request field it concerns,
+// such as legs[1].mode for a factor lookup that rejected "transport".
+func renameField(err error, field string) error {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		verr.Field = field
+	}
+	return err
+}
+
+var calculators = map[string]Calculator{}
+
+// RegisterCalculator adds a calculator to the registry. It panics on duplicate
+// activities since that is always a programming error.
+func RegisterCalculator(calc Calculator) {
+	activity := calc.Activity()
+	if _, exists := calculators[activity]; exists {
+		panic("calculator already registered for activity " + activity)
+	}
+	calculators[activity] = calc
+}
+
+// lookupCalculator returns the calculator for an activity, falling back to the
+// generic amount × factor calculator for activities that only exist as factors.
+func lookupCalculator(activity string) Calculator {
+	if calc, exists := calculators[activity]; exists {
+		return calc
+	}
+	return genericCalculator{activity: activity}
+}
+
+// registeredCalculators returns all registered calculators sorted by activity.
+func registeredCalculators() []Calculator {
+	list := make([]Calculator, 0, len(calculators))
+	for _, calc := range calculators {
+		list = append(list, calc)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Activity() < list[j].Activity()
+	})
+	return list
+}
+
+// defaultModes lists the modes that have a built-in emission factor, so the
+// activity catalogue never advertises options we cannot calculate.
+func defaultModes(activity string) []string {
+	var modes []string
+	for mode := range defaultEmissionFactors[activity] {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+func requirePositive(field string, value float64) error {
+	if value <= 0 {
+		return invalidField(field, "must be greater than zero")
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// genericCalculator handles activities without a dedicated calculator.
+type genericCalculator struct {
+	activity string
+}
+
+func (g genericCalculator) Activity() string { return g.activity }
+
+func (g genericCalculator) Describe() ActivityDescription {
+	return ActivityDescription{Description: "Generic amount × emission factor calculation"}
+}
+
+func (g genericCalculator) RequiredFields() []string {
+	return []string{"activity", "amount"}
+}
+
+func (g genericCalculator) Formula() string { return "amount × emission_factor" }
+
+func (g genericCalculator) Validate(req CalculateRequest) error {
+	return requirePositive("amount", req.Amount)
+}
+
+func (g genericCalculator) Calculate(factors FactorSource, req CalculateRequest) (CalculatorResult, error) {
+	factor, err := factors.EmissionFactor(FactorQuery{Activity: req.Activity, Mode: req.Transport, Region: requestRegion(req)})
+	if err != nil {
+		return CalculatorResult{}, err
+	}
+
+	return CalculatorResult{
+		CarbonFootprint: req.Amount * factor.Factor,
+		Gases:           gasAmounts(factor, req.Amount),
+		Breakdown: map[string]interface{}{
+			"activity": req.Activity,
+			"amount":   req.Amount,
+			"factor":   factor.Factor,
+			"unit":     factor.Unit,
+		},
+	}, nil
+}
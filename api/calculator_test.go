@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCalculatorRegistry(t *testing.T) {
+	want := []string{"electricity", "flight", "fuel", "shipment", "shipping"}
+	registered := registeredCalculators()
+	if len(registered) != len(want) {
+		t.Fatalf("%d calculators registered, want %d", len(registered), len(want))
+	}
+	for i, calc := range registered {
+		if calc.Activity() != want[i] {
+			t.Errorf("calculator %d is %s, want %s", i, calc.Activity(), want[i])
+		}
+		if lookupCalculator(want[i]) != calc {
+			t.Errorf("lookupCalculator(%q) did not return the registered calculator", want[i])
+		}
+	}
+
+	if calc, ok := lookupCalculator("paper").(genericCalculator); !ok || calc.Activity() != "paper" {
+		t.Errorf("lookupCalculator(paper) = %#v, want the generic calculator", lookupCalculator("paper"))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a second fuel calculator did not panic")
+		}
+	}()
+	RegisterCalculator(fuelCalculator{})
+}
+
+func TestCalculateValidation(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	shipment := func(legs []ShipmentLeg, hubs []ShipmentHub) CalculateRequest {
+		return CalculateRequest{Activity: "shipment", Weight: 1000, Legs: legs, Hubs: hubs}
+	}
+	road := ShipmentLeg{Mode: "road", Distance: 100}
+
+	tests := []struct {
+		name  string
+		req   CalculateRequest
+		field string
+	}{
+		{"fuel", CalculateRequest{Activity: "fuel", Amount: 10, Transport: "diesel"}, ""},
+		{"fuel without amount", CalculateRequest{Activity: "fuel", Transport: "diesel"}, "amount"},
+		{"fuel without type", CalculateRequest{Activity: "fuel", Amount: 10}, "transport"},
+		{"unknown fuel", CalculateRequest{Activity: "fuel", Amount: 10, Transport: "kerosene_x"}, "transport"},
+		{"shipping", CalculateRequest{Activity: "shipping", Weight: 500, Distance: 100, Transport: "road"}, ""},
+		{"unknown shipping mode", CalculateRequest{Activity: "shipping", Weight: 500, Distance: 100, Transport: "truck"}, "transport"},
+		{"shipping without weight", CalculateRequest{Activity: "shipping", Distance: 100, Transport: "road"}, "weight"},
+		{"shipment", shipment([]ShipmentLeg{road}, []ShipmentHub{{Type: "warehouse"}}), ""},
+		{"shipment without legs", shipment(nil, nil), "legs"},
+		{"unknown leg mode", shipment([]ShipmentLeg{road, {Mode: "truck", Distance: 10}}, nil), "legs[1].mode"},
+		{"unknown hub type", shipment([]ShipmentLeg{road}, []ShipmentHub{{Type: "warehouse_x"}}), "hubs[0].type"},
+		{"leg load factor", shipment([]ShipmentLeg{{Mode: "road", Distance: 10, LoadFactor: 2}}, nil), "legs[0].load_factor"},
+		{"generic activity", CalculateRequest{Activity: "paper", Amount: 5}, ""},
+		{"generic activity without amount", CalculateRequest{Activity: "paper"}, "amount"},
+		{"unknown GWP set", CalculateRequest{Activity: "fuel", Amount: 10, Transport: "diesel", GWP: "AR3"}, "gwp"},
+		{"too many tags", CalculateRequest{Activity: "paper", Amount: 5, Tags: make([]string, 100)}, "tags"},
+	}
+	for _, tt := range tests {
+		_, err := cs.calculateCarbonFootprint(tt.req, false)
+		var validation *ValidationError
+		switch {
+		case tt.field == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.field != "" && !errors.As(err, &validation):
+			t.Errorf("%s: error = %v, want a validation error", tt.name, err)
+		case tt.field != "" && validation.Field != tt.field:
+			t.Errorf("%s: error %q is about %q, want %q", tt.name, validation.Message, validation.Field, tt.field)
+		}
+	}
+}
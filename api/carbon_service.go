@@ -1,10 +1,10 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"log"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -13,8 +13,13 @@ import (
 )
 
 type CarbonService struct {
-	db    *sql.DB
-	cache *redis.Client
+	store   Storage
+	cache   *redis.Client
+	limiter *RateLimiter
+	uploads *uploadJobs
+	outbox  *CalculationOutbox
+	// intensity fills gaps in stored hourly grid intensity; nil for none
+	intensity IntensityProvider
 }
 
 type CalculateRequest struct {
@@ -27,36 +32,106 @@ type CalculateRequest struct {
 	Amount    float64                `json:"amount,omitempty"`
 	Unit      string                 `json:"unit,omitempty"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+
+	// ActivityDate selects the emission factors valid when the activity took
+	// place; it defaults to today.
+	ActivityDate *Date `json:"activity_date,omitempty"`
+
+	// Region selects regional emission factors: a country code such as "DE"
+	// or a subregion such as the eGRID "US-CAMX". Country may carry the
+	// country separately ("US" with region "CAMX"). Both default to WORLD.
+	Region  string `json:"region,omitempty"`
+	Country string `json:"country,omitempty"`
+
+	// Tags label the stored calculation for filtering it later.
+	Tags []string `json:"tags,omitempty"`
+
+	// Scope and Scope3Category override the GHG Protocol classification.
+	Scope          int `json:"scope,omitempty"`
+	Scope3Category int `json:"scope3_category,omitempty"`
+
+	// GWP selects the IPCC assessment report (AR4, AR5 or AR6) whose global
+	// warming potentials convert gases to CO2e; it defaults to AR5. Only
+	// factors with a gas split can be converted: the breakdown reports
+	// gwp_applied false when any factor was used at its published CO2e.
+	GWP string `json:"gwp,omitempty"`
+
+	// Passenger travel
+	Passengers       int     `json:"passengers,omitempty"`
+	CabinClass       string  `json:"cabin_class,omitempty"`
+	RoundTrip        bool    `json:"round_trip,omitempty"`
+	RadiativeForcing float64 `json:"radiative_forcing,omitempty"`
+
+	// Multi-leg freight
+	Legs []ShipmentLeg `json:"legs,omitempty"`
+	Hubs []ShipmentHub `json:"hubs,omitempty"`
+
+	// Scope 2 electricity. Scope2Method picks the figure reported as the
+	// footprint, location (default) or market; both are always returned.
+	Scope2Method   string              `json:"scope2_method,omitempty"`
+	SupplierFactor *float64            `json:"supplier_factor,omitempty"` // kg CO2e per kWh
+	Certificates   []EnergyCertificate `json:"certificates,omitempty"`
+	// LoadProfile splits the consumption into intervals so the location
+	// based footprint uses hourly grid intensity; amount may then be omitted.
+	LoadProfile []LoadInterval `json:"load_profile,omitempty"`
 }
 
 type CalculateResponse struct {
-	CarbonFootprint float64                `json:"carbon_footprint"`
-	Unit            string                 `json:"unit"`
-	Breakdown       map[string]interface{} `json:"breakdown"`
-	Suggestions     []string               `json:"suggestions"`
-	Calculation     map[string]interface{} `json:"calculation"`
-	Timestamp       time.Time              `json:"timestamp"`
+	// CalculationID identifies the stored calculation.
+	CalculationID   string  `json:"calculation_id"`
+	CarbonFootprint float64 `json:"carbon_footprint"`
+	Unit            string  `json:"unit"`
+	// Uncertainty is the range of the footprint when its inputs are drawn
+	// from their uncertainty, with a data quality score. It is computed only
+	// when asked for with ?uncertainty=true.
+	Uncertainty *UncertaintyResult `json:"uncertainty,omitempty"`
+	// Scope is the GHG Protocol scope, zero when the activity is unclassified.
+	Scope          int    `json:"scope,omitempty"`
+	Scope3Category int    `json:"scope3_category,omitempty"`
+	ScopeSource    string `json:"scope_source,omitempty"`
+	// Scope2 holds both Scope 2 figures for electricity.
+	Scope2      *Scope2Result          `json:"scope_2,omitempty"`
+	Breakdown   map[string]interface{} `json:"breakdown"`
+	Suggestions []string               `json:"suggestions"`
+	Calculation map[string]interface{} `json:"calculation"`
+	Factors     []FactorReference      `json:"factors"`
+	Timestamp   time.Time              `json:"timestamp"`
 }
 
 type EmissionFactor struct {
-	ID            int     `json:"id"`
-	Activity      string  `json:"activity"`
-	TransportMode string  `json:"transport_mode"`
-	Factor        float64 `json:"factor"`
-	Unit          string  `json:"unit"`
-	Source        string  `json:"source"`
+	ID            int         `json:"id"`
+	Activity      string      `json:"activity"`
+	TransportMode string      `json:"transport_mode"`
+	Region        string      `json:"region"`
+	Factor        float64     `json:"factor"`
+	Unit          string      `json:"unit"`
+	Source        string      `json:"source"`
+	Version       string      `json:"version"`
+	ValidFrom     *Date       `json:"valid_from,omitempty"`
+	ValidTo       *Date       `json:"valid_to,omitempty"`
+	Gases         *GasFactors `json:"gases,omitempty"`
+	// Scope and Scope3Category classify emissions from this factor; unset
+	// means the activity's default applies.
+	Scope          int `json:"scope,omitempty"`
+	Scope3Category int `json:"scope3_category,omitempty"`
+	// Uncertainty is the factor's published range; unset means the
+	// activity's default applies.
+	Uncertainty *Uncertainty `json:"uncertainty,omitempty"`
 }
 
-func NewCarbonService(db *sql.DB, cache *redis.Client) *CarbonService {
+func NewCarbonService(store Storage, cache *redis.Client) *CarbonService {
 	return &CarbonService{
-		db:    db,
-		cache: cache,
+		store:   store,
+		cache:   cache,
+		limiter: NewRateLimiter(cache, ratePlansFromEnv()),
+		uploads: newUploadJobs(),
+		outbox:  NewCalculationOutbox(store, cache),
+
+		intensity: intensityProviderFromEnv(),
 	}
 }
 
 func (cs *CarbonService) CalculateCarbon(c *fiber.Ctx) error {
-	start := time.Now()
-
 	var req CalculateRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{
@@ -74,7 +149,14 @@ func (cs *CarbonService) CalculateCarbon(c *fiber.Ctx) error {
 	}
 
 	// Calculate carbon footprint
-	result, err := cs.calculateCarbonFootprint(req)
+	result, err := cs.calculateCarbonFootprint(req, c.QueryBool("uncertainty"))
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   true,
+			"message": validationErr.Error(),
+		})
+	}
 	if err != nil {
 		log.Printf("Calculation error: %v", err)
 		return c.Status(500).JSON(fiber.Map{
@@ -83,195 +165,177 @@ func (cs *CarbonService) CalculateCarbon(c *fiber.Ctx) error {
 		})
 	}
 
-	// Store calculation in database
-	go cs.storeCalculation(req, result)
-
-	// Track API usage
-	go cs.trackAPIUsage("calculate", c.Get("User-ID", "anonymous"), time.Since(start))
+	record := newCalculationRecord(req, result, principalFrom(c))
+	if err := cs.persistCalculations(c, []CalculationRecord{record}); err != nil {
+		return err
+	}
 
 	return c.JSON(result)
 }
 
-func (cs *CarbonService) calculateCarbonFootprint(req CalculateRequest) (*CalculateResponse, error) {
-	// Get emission factor from database
-	factor, err := cs.getEmissionFactor(req.Activity, req.Transport)
+// calculateCarbonFootprint runs req through its calculator. The Monte Carlo
+// uncertainty range repeats the calculation many times, so it is only
+// added when withUncertainty is set.
+func (cs *CarbonService) calculateCarbonFootprint(req CalculateRequest, withUncertainty bool) (*CalculateResponse, error) {
+	calc := lookupCalculator(req.Activity)
+	if err := validateTags(req.Tags); err != nil {
+		return nil, err
+	}
+	if err := validateGHGScope(req.Scope, req.Scope3Category); err != nil {
+		return nil, err
+	}
+	if len(requestRegion(req)) > 50 {
+		return nil, invalidField("region", "must be at most 50 characters")
+	}
+	gwp, err := lookupGWPSet(req.GWP)
+	if err != nil {
+		return nil, err
+	}
+	if err := calc.Validate(req); err != nil {
+		return nil, err
+	}
+
+	activityDate := time.Now().UTC()
+	if req.ActivityDate != nil {
+		activityDate = req.ActivityDate.Time
+	}
+	factors := &factorRecorder{source: cs, at: activityDate, gwp: gwp}
+
+	result, err := calc.Calculate(factors, req)
 	if err != nil {
 		return nil, err
 	}
+	if result.Breakdown == nil {
+		result.Breakdown = map[string]interface{}{}
+	}
+	result.Breakdown["gwp"] = gwp
+	result.Breakdown["gwp_applied"] = !factors.unsplit
+	if result.Gases != nil {
+		result.Breakdown["gases"] = result.Gases.breakdown(gwp)
+	}
 
-	var carbonFootprint float64
-	var breakdown map[string]interface{}
-	var calculation map[string]interface{}
-
-	switch req.Activity {
-	case "shipping":
-		carbonFootprint, breakdown, calculation = cs.calculateShipping(req, factor)
-	case "electricity":
-		carbonFootprint, breakdown, calculation = cs.calculateElectricity(req, factor)
-	case "fuel":
-		carbonFootprint, breakdown, calculation = cs.calculateFuel(req, factor)
-	default:
-		// Generic calculation
-		carbonFootprint = req.Amount * factor.Factor
-		breakdown = map[string]interface{}{
-			"activity": req.Activity,
-			"amount":   req.Amount,
-			"factor":   factor.Factor,
-			"unit":     factor.Unit,
-		}
-		calculation = map[string]interface{}{
-			"formula": "amount × emission_factor",
-			"values":  breakdown,
+	var uncertainty *UncertaintyResult
+	if withUncertainty {
+		if uncertainty, err = propagateUncertainty(calc, factors, req); err != nil {
+			return nil, err
 		}
 	}
 
+	calculation := map[string]interface{}{
+		"formula": calc.Formula(),
+		"values":  result.Breakdown,
+		"result":  result.CarbonFootprint,
+	}
+
 	// Generate suggestions
-	suggestions := cs.generateSuggestions(req, carbonFootprint)
+	suggestions := cs.generateSuggestions(calc, req, result.CarbonFootprint)
+	scope, category, scopeSource := classifyCalculation(req, factors.used)
 
 	return &CalculateResponse{
-		CarbonFootprint: math.Round(carbonFootprint*1000) / 1000, // Round to 3 decimal places
+		CalculationID:   uuid.NewString(),
+		CarbonFootprint: math.Round(result.CarbonFootprint*1000) / 1000, // Round to 3 decimal places
 		Unit:            "kg_co2e",
-		Breakdown:       breakdown,
+		Uncertainty:     uncertainty,
+		Scope:           scope,
+		Scope3Category:  category,
+		ScopeSource:     scopeSource,
+		Scope2:          result.Scope2,
+		Breakdown:       result.Breakdown,
 		Suggestions:     suggestions,
 		Calculation:     calculation,
+		Factors:         factors.used,
 		Timestamp:       time.Now(),
 	}, nil
 }
 
-func (cs *CarbonService) calculateShipping(req CalculateRequest, factor EmissionFactor) (float64, map[string]interface{}, map[string]interface{}) {
-	// If distance not provided, estimate based on from/to
-	distance := req.Distance
-	if distance == 0 && req.From != "" && req.To != "" {
-		distance = cs.estimateDistance(req.From, req.To)
-	}
-
-	// Calculate: weight (tonnes) × distance (km) × emission factor
-	weightTonnes := req.Weight / 1000.0
-	carbonFootprint := weightTonnes * distance * factor.Factor
-
-	breakdown := map[string]interface{}{
-		"weight_kg":       req.Weight,
-		"weight_tonnes":   weightTonnes,
-		"distance_km":     distance,
-		"transport_mode":  req.Transport,
-		"emission_factor": factor.Factor,
-		"from":            req.From,
-		"to":              req.To,
-	}
-
-	calculation := map[string]interface{}{
-		"formula": "weight_tonnes × distance_km × emission_factor",
-		"values":  breakdown,
-		"result":  carbonFootprint,
-	}
-
-	return carbonFootprint, breakdown, calculation
+// EmissionFactor implements FactorSource for the registered calculators.
+func (cs *CarbonService) EmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	return cs.getEmissionFactor(q)
 }
 
-func (cs *CarbonService) calculateElectricity(req CalculateRequest, factor EmissionFactor) (float64, map[string]interface{}, map[string]interface{}) {
-	// Calculate: amount (kWh) × emission factor
-	carbonFootprint := req.Amount * factor.Factor
-
-	breakdown := map[string]interface{}{
-		"energy_kwh":      req.Amount,
-		"energy_source":   req.Transport,
-		"emission_factor": factor.Factor,
-		"grid_mix":        "regional_average", // Could be enhanced with location-specific data
+// getEmissionFactor returns the factor valid at q.At (or now), preferring the
+// most recently effective version when several overlap. A region without a
+// factor falls back to its country, continent and finally WORLD; at each
+// level a stored factor wins over the embedded grid dataset. Only missing
+// factors fall back; a store that fails is an error, not a reason to
+// answer with built-in numbers.
+func (cs *CarbonService) getEmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	if q.At.IsZero() {
+		q.At = time.Now().UTC()
 	}
 
-	calculation := map[string]interface{}{
-		"formula": "energy_kwh × emission_factor",
-		"values":  breakdown,
-		"result":  carbonFootprint,
-	}
-
-	return carbonFootprint, breakdown, calculation
-}
-
-func (cs *CarbonService) calculateFuel(req CalculateRequest, factor EmissionFactor) (float64, map[string]interface{}, map[string]interface{}) {
-	// Calculate: amount (liters) × emission factor
-	carbonFootprint := req.Amount * factor.Factor
-
-	breakdown := map[string]interface{}{
-		"fuel_liters":     req.Amount,
-		"fuel_type":       req.Transport,
-		"emission_factor": factor.Factor,
-		"combustion":      "direct_emissions",
+	for _, region := range regionChain(q.Region) {
+		q.Region = region
+		factor, err := cs.store.FindEmissionFactor(q)
+		if err == nil {
+			return factor, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return EmissionFactor{}, err
+		}
+		if factor, ok := builtinRegionalFactor(q); ok {
+			return factor, nil
+		}
 	}
-
-	calculation := map[string]interface{}{
-		"formula": "fuel_liters × emission_factor",
-		"values":  breakdown,
-		"result":  carbonFootprint,
+	if q.Optional {
+		return EmissionFactor{}, ErrNotFound
 	}
-
-	return carbonFootprint, breakdown, calculation
-}
-
-func (cs *CarbonService) getEmissionFactor(activity, transport string) (EmissionFactor, error) {
-	var factor EmissionFactor
-
-	query := `
-		SELECT id, activity, transport_mode, factor, unit, source 
-		FROM emission_factors 
-		WHERE activity = $1
-	`
-
-	args := []interface{}{activity}
-
-	if transport != "" {
-		query += " AND transport_mode = $2"
-		args = append(args, transport)
+	// Fallback to default factors if no stored factor applies
+	factor, ok := cs.getDefaultEmissionFactor(q.Activity, q.Mode)
+	if !ok {
+		return EmissionFactor{}, invalidField("transport", "no emission factor for %s mode %q, built-in modes are %s",
+			q.Activity, q.Mode, strings.Join(defaultModes(q.Activity), ", "))
 	}
-
-	query += " ORDER BY id LIMIT 1"
-
-	err := cs.db.QueryRow(query, args...).Scan(
-		&factor.ID,
-		&factor.Activity,
-		&factor.TransportMode,
-		&factor.Factor,
-		&factor.Unit,
-		&factor.Source,
-	)
-
-	if err != nil {
-		// Fallback to default factors if database is unavailable
-		return cs.getDefaultEmissionFactor(activity, transport), nil
-	}
-
 	return factor, nil
 }
 
-func (cs *CarbonService) getDefaultEmissionFactor(activity, transport string) EmissionFactor {
-	// Fallback emission factors for when database is unavailable
-	defaultFactors := map[string]map[string]EmissionFactor{
-		"shipping": {
-			"air":  {Activity: "shipping", TransportMode: "air", Factor: 0.996, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
-			"sea":  {Activity: "shipping", TransportMode: "sea", Factor: 0.015, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
-			"road": {Activity: "shipping", TransportMode: "road", Factor: 0.209, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
-			"rail": {Activity: "shipping", TransportMode: "rail", Factor: 0.028, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
-		},
-		"electricity": {
-			"grid":  {Activity: "electricity", TransportMode: "grid", Factor: 0.525, Unit: "kg_co2e_per_kwh", Source: "IEA 2023"},
-			"solar": {Activity: "electricity", TransportMode: "solar", Factor: 0.041, Unit: "kg_co2e_per_kwh", Source: "IPCC 2023"},
-			"wind":  {Activity: "electricity", TransportMode: "wind", Factor: 0.011, Unit: "kg_co2e_per_kwh", Source: "IPCC 2023"},
-		},
-		"fuel": {
-			"gasoline":    {Activity: "fuel", TransportMode: "gasoline", Factor: 2.31, Unit: "kg_co2e_per_liter", Source: "EPA 2023"},
-			"diesel":      {Activity: "fuel", TransportMode: "diesel", Factor: 2.68, Unit: "kg_co2e_per_liter", Source: "EPA 2023"},
-			"natural_gas": {Activity: "fuel", TransportMode: "natural_gas", Factor: 0.202, Unit: "kg_co2e_per_kwh", Source: "EPA 2023"},
-		},
-	}
+// defaultEmissionFactors are used when the database is unavailable.
+var defaultEmissionFactors = map[string]map[string]EmissionFactor{
+	"shipping": {
+		"air":  {Activity: "shipping", TransportMode: "air", Factor: 0.996, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
+		"sea":  {Activity: "shipping", TransportMode: "sea", Factor: 0.015, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
+		"road": {Activity: "shipping", TransportMode: "road", Factor: 0.209, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
+		"rail": {Activity: "shipping", TransportMode: "rail", Factor: 0.028, Unit: "kg_co2e_per_tonne_km", Source: "IPCC 2023"},
+	},
+	"electricity": {
+		"grid":  {Activity: "electricity", TransportMode: "grid", Factor: 0.525, Unit: "kg_co2e_per_kwh", Source: "IEA 2023"},
+		"solar": {Activity: "electricity", TransportMode: "solar", Factor: 0.041, Unit: "kg_co2e_per_kwh", Source: "IPCC 2023"},
+		"wind":  {Activity: "electricity", TransportMode: "wind", Factor: 0.011, Unit: "kg_co2e_per_kwh", Source: "IPCC 2023"},
+	},
+	"fuel": {
+		"gasoline":    {Activity: "fuel", TransportMode: "gasoline", Factor: 2.31, Unit: "kg_co2e_per_liter", Source: "EPA 2023"},
+		"diesel":      {Activity: "fuel", TransportMode: "diesel", Factor: 2.68, Unit: "kg_co2e_per_liter", Source: "EPA 2023"},
+		"natural_gas": {Activity: "fuel", TransportMode: "natural_gas", Factor: 0.202, Unit: "kg_co2e_per_kwh", Source: "EPA 2023"},
+	},
+	"flight": {
+		"domestic":                  {Activity: "flight", TransportMode: "domestic", Factor: 0.13357, Unit: "kg_co2e_per_pkm", Source: "DEFRA 2023"},
+		"short_haul_economy":        {Activity: "flight", TransportMode: "short_haul_economy", Factor: 0.08085, Unit: "kg_co2e_per_pkm", Source: "DEFRA 2023"},
+		"short_haul_business":       {Activity: "flight", TransportMode: "short_haul_business", Factor: 0.12127, Unit: "kg_co2e_per_pkm", Source: "DEFRA 2023"},
+		"long_haul_economy":         {Activity: "flight", TransportMode: "long_haul_economy", Factor: 0.07755, Unit: "kg_co2e_per_pkm", Source: "DEFRA 2023"},
+		"long_haul_premium_economy": {Activity: "flight", TransportMode: "long_haul_premium_economy", Factor: 0.12408, Unit: "kg_co2e_per_pkm", Source: "DEFRA 2023"},
+		"long_haul_business":        {Activity: "flight", TransportMode: "long_haul_business", Factor: 0.22489, Unit: "kg_co2e_per_pkm", Source: "DEFRA 2023"},
+		"long_haul_first":           {Activity: "flight", TransportMode: "long_haul_first", Factor: 0.31021, Unit: "kg_co2e_per_pkm", Source: "DEFRA 2023"},
+	},
+	"hub": {
+		"warehouse":          {Activity: "hub", TransportMode: "warehouse", Factor: 1.7, Unit: "kg_co2e_per_tonne", Source: "GLEC 2023"},
+		"transhipment":       {Activity: "hub", TransportMode: "transhipment", Factor: 1.2, Unit: "kg_co2e_per_tonne", Source: "GLEC 2023"},
+		"container_terminal": {Activity: "hub", TransportMode: "container_terminal", Factor: 0.6, Unit: "kg_co2e_per_tonne", Source: "GLEC 2023"},
+	},
+}
 
-	if activityFactors, exists := defaultFactors[activity]; exists {
-		if factor, exists := activityFactors[transport]; exists {
-			return factor
-		}
-		// Return first available factor for the activity
-		for _, factor := range activityFactors {
-			return factor
+// getDefaultEmissionFactor returns the built-in factor of a mode. Activities
+// without built-in factors get a placeholder of 1 kg per unit; an unknown
+// mode of a built-in activity is not found, since no other mode's factor
+// would be right for it.
+func (cs *CarbonService) getDefaultEmissionFactor(activity, transport string) (EmissionFactor, bool) {
+	if activityFactors, exists := defaultEmissionFactors[activity]; exists {
+		factor, exists := activityFactors[transport]
+		if !exists {
+			return EmissionFactor{}, false
 		}
+		factor.Version = defaultFactorVersion
+		factor.Region = worldRegion
+		return factor, true
 	}
 
 	// Ultimate fallback
@@ -281,62 +345,16 @@ func (cs *CarbonService) getDefaultEmissionFactor(activity, transport string) Em
 		Factor:        1.0,
 		Unit:          "kg_co2e_per_unit",
 		Source:        "Default",
-	}
-}
-
-func (cs *CarbonService) estimateDistance(from, to string) float64 {
-	// Simplified distance estimation - in production, use a proper geocoding service
-	distances := map[string]map[string]float64{
-		"NYC": {
-			"London":     5585, // km
-			"Paris":      5837,
-			"Tokyo":      10847,
-			"Sydney":     15993,
-			"LosAngeles": 3944,
-		},
-		"London": {
-			"NYC":   5585,
-			"Paris": 344,
-			"Tokyo": 9561,
-		},
-		"Paris": {
-			"NYC":    5837,
-			"London": 344,
-			"Tokyo":  9714,
-		},
-	}
-
-	if fromDistances, exists := distances[from]; exists {
-		if distance, exists := fromDistances[to]; exists {
-			return distance
-		}
-	}
-
-	// Default distance for unknown routes
-	return 1000.0
+		Region:        worldRegion,
+		Version:       defaultFactorVersion,
+	}, true
 }
 
-func (cs *CarbonService) generateSuggestions(req CalculateRequest, carbonFootprint float64) []string {
+func (cs *CarbonService) generateSuggestions(calc Calculator, req CalculateRequest, carbonFootprint float64) []string {
 	var suggestions []string
 
-	switch req.Activity {
-	case "shipping":
-		if req.Transport == "air" {
-			suggestions = append(suggestions, "Consider sea freight to reduce emissions by 98%")
-			suggestions = append(suggestions, "Use rail transport when possible for 97% reduction")
-		}
-		if req.Transport == "road" {
-			suggestions = append(suggestions, "Switch to rail transport for 87% emissions reduction")
-			suggestions = append(suggestions, "Optimize routes to reduce distance")
-		}
-	case "electricity":
-		if req.Transport == "grid" {
-			suggestions = append(suggestions, "Switch to renewable energy for 92% reduction")
-			suggestions = append(suggestions, "Install solar panels for clean energy")
-		}
-	case "fuel":
-		suggestions = append(suggestions, "Consider electric vehicles for zero direct emissions")
-		suggestions = append(suggestions, "Use biofuels to reduce carbon intensity")
+	if suggester, ok := calc.(Suggester); ok {
+		suggestions = append(suggestions, suggester.Suggestions(req, carbonFootprint)...)
 	}
 
 	// General suggestions based on footprint size
@@ -347,66 +365,71 @@ func (cs *CarbonService) generateSuggestions(req CalculateRequest, carbonFootpri
 	return suggestions
 }
 
-func (cs *CarbonService) storeCalculation(req CalculateRequest, result *CalculateResponse) {
-	inputJSON, _ := json.Marshal(req)
-
-	_, err := cs.db.Exec(`
-		INSERT INTO calculations (activity, input_data, carbon_footprint, unit, user_id)
-		VALUES ($1, $2, $3, $4, $5)
-	`, req.Activity, inputJSON, result.CarbonFootprint, result.Unit, uuid.New().String())
+func newCalculationRecord(req CalculateRequest, result *CalculateResponse, principal Principal) CalculationRecord {
+	return CalculationRecord{
+		CalculationID:   result.CalculationID,
+		Activity:        req.Activity,
+		Input:           req,
+		CarbonFootprint: result.CarbonFootprint,
+		Unit:            result.Unit,
+		UserID:          principal.Subject,
+		OrgID:           principal.Org,
+		Factors:         result.Factors,
+		ActivityDate:    req.ActivityDate,
+		Tags:            req.Tags,
+		Scope:           result.Scope,
+		Scope3Category:  result.Scope3Category,
+		Result:          result,
+		CreatedAt:       result.Timestamp,
+	}
+}
 
-	if err != nil {
-		log.Printf("Failed to store calculation: %v", err)
+// persistCalculations queues records for the outbox, or with ?sync=true
+// stores them before responding so the caller knows they were saved.
+func (cs *CarbonService) persistCalculations(c *fiber.Ctx, records []CalculationRecord) error {
+	if !c.QueryBool("sync") {
+		cs.outbox.Enqueue(records)
+		return nil
 	}
+	if _, err := cs.store.SaveCalculations(records); err != nil {
+		log.Printf("Failed to store %d calculations: %v", len(records), err)
+		return fiber.NewError(fiber.StatusServiceUnavailable, "Failed to store calculation, nothing was recorded")
+	}
+	return nil
 }
 
-func (cs *CarbonService) trackAPIUsage(endpoint, userID string, responseTime time.Duration) {
-	_, err := cs.db.Exec(`
-		INSERT INTO api_usage (endpoint, user_id, response_time_ms)
-		VALUES ($1, $2, $3)
-	`, endpoint, userID, responseTime.Milliseconds())
+// trackUsage records successful requests to a route in api_usage.
+func (cs *CarbonService) trackUsage(endpoint string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		if err == nil && c.Response().StatusCode() < 400 {
+			go cs.trackAPIUsage(endpoint, principalFrom(c), time.Since(start))
+		}
+		return err
+	}
+}
 
-	if err != nil {
+func (cs *CarbonService) trackAPIUsage(endpoint string, principal Principal, responseTime time.Duration) {
+	if err := cs.store.RecordAPIUsage(endpoint, principal, responseTime); err != nil {
 		log.Printf("Failed to track API usage: %v", err)
 	}
 }
 
 func (cs *CarbonService) GetActivities(c *fiber.Ctx) error {
-	activities := map[string]interface{}{
-		"shipping": map[string]interface{}{
-			"description":     "Calculate carbon footprint for freight transport",
-			"transport_modes": []string{"air", "sea", "road", "rail"},
-			"required_fields": []string{"activity", "weight", "distance_or_locations", "transport"},
-			"example": map[string]interface{}{
-				"activity":  "shipping",
-				"weight":    500,
-				"from":      "NYC",
-				"to":        "London",
-				"transport": "air",
-			},
-		},
-		"electricity": map[string]interface{}{
-			"description":     "Calculate carbon footprint for electricity consumption",
-			"energy_sources":  []string{"grid", "solar", "wind", "coal", "gas"},
-			"required_fields": []string{"activity", "amount", "transport"},
-			"example": map[string]interface{}{
-				"activity":  "electricity",
-				"amount":    100,
-				"unit":      "kwh",
-				"transport": "grid",
-			},
-		},
-		"fuel": map[string]interface{}{
-			"description":     "Calculate carbon footprint for fuel consumption",
-			"fuel_types":      []string{"gasoline", "diesel", "natural_gas"},
-			"required_fields": []string{"activity", "amount", "transport"},
-			"example": map[string]interface{}{
-				"activity":  "fuel",
-				"amount":    50,
-				"unit":      "liters",
-				"transport": "gasoline",
-			},
-		},
+	activities := make(map[string]interface{})
+	for _, calc := range registeredCalculators() {
+		desc := calc.Describe()
+		entry := map[string]interface{}{
+			"description":     desc.Description,
+			"required_fields": calc.RequiredFields(),
+			"formula":         calc.Formula(),
+			"example":         desc.Example,
+		}
+		if desc.OptionsKey != "" {
+			entry[desc.OptionsKey] = desc.Options
+		}
+		activities[calc.Activity()] = entry
 	}
 
 	return c.JSON(fiber.Map{
@@ -416,34 +439,27 @@ func (cs *CarbonService) GetActivities(c *fiber.Ctx) error {
 }
 
 func (cs *CarbonService) GetEmissionFactors(c *fiber.Ctx) error {
-	rows, err := cs.db.Query(`
-		SELECT activity, transport_mode, factor, unit, source
-		FROM emission_factors
-		ORDER BY activity, transport_mode
-	`)
+	// Optional point-in-time view: only factors valid on the given date
+	var at time.Time
+	if value := c.Query("at"); value != "" {
+		date, err := parseDate(value)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":   true,
+				"message": err.Error(),
+			})
+		}
+		at = date
+	}
+
+	factors, err := cs.store.ListEmissionFactors(at)
 	if err != nil {
+		log.Printf("Failed to fetch emission factors: %v", err)
 		return c.Status(500).JSON(fiber.Map{
 			"error":   true,
 			"message": "Failed to fetch emission factors",
 		})
 	}
-	defer rows.Close()
-
-	var factors []EmissionFactor
-	for rows.Next() {
-		var factor EmissionFactor
-		err := rows.Scan(
-			&factor.Activity,
-			&factor.TransportMode,
-			&factor.Factor,
-			&factor.Unit,
-			&factor.Source,
-		)
-		if err != nil {
-			continue
-		}
-		factors = append(factors, factor)
-	}
 
 	return c.JSON(fiber.Map{
 		"emission_factors": factors,
@@ -453,43 +469,26 @@ func (cs *CarbonService) GetEmissionFactors(c *fiber.Ctx) error {
 }
 
 func (cs *CarbonService) GetAnalytics(c *fiber.Ctx) error {
-	var totalCalculations int
-	var avgResponseTime float64
-	var totalCarbonCalculated float64
-
-	// Get total calculations
-	cs.db.QueryRow("SELECT COUNT(*) FROM calculations").Scan(&totalCalculations)
-
-	// Get average response time
-	cs.db.QueryRow("SELECT AVG(response_time_ms) FROM api_usage").Scan(&avgResponseTime)
-
-	// Get total carbon calculated
-	cs.db.QueryRow("SELECT SUM(carbon_footprint) FROM calculations").Scan(&totalCarbonCalculated)
-
-	// Get top activities
-	rows, _ := cs.db.Query(`
-		SELECT activity, COUNT(*) as count
-		FROM calculations
-		GROUP BY activity
-		ORDER BY count DESC
-		LIMIT 5
-	`)
-	defer rows.Close()
-
-	topActivities := make(map[string]int)
-	for rows.Next() {
-		var activity string
-		var count int
-		rows.Scan(&activity, &count)
-		topActivities[activity] = count
+	analytics, err := cs.store.Analytics()
+	if err != nil {
+		log.Printf("Failed to fetch analytics: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error":   true,
+			"message": "Failed to fetch analytics",
+		})
+	}
+	for scope, total := range analytics.EmissionsByScope {
+		analytics.EmissionsByScope[scope] = roundTo(total, 2)
 	}
 
 	return c.JSON(fiber.Map{
 		"analytics": map[string]interface{}{
-			"total_calculations":      totalCalculations,
-			"avg_response_time_ms":    math.Round(avgResponseTime*100) / 100,
-			"total_carbon_calculated": math.Round(totalCarbonCalculated*100) / 100,
-			"top_activities":          topActivities,
+			"total_calculations":      analytics.TotalCalculations,
+			"avg_response_time_ms":    math.Round(analytics.AvgResponseTimeMs*100) / 100,
+			"total_carbon_calculated": math.Round(analytics.TotalCarbonCalculated*100) / 100,
+			"top_activities":          analytics.TopActivities,
+			"emissions_by_scope":      analytics.EmissionsByScope,
+			"scope_3_by_category":     scope3CategoryTotals(analytics.Scope3ByCategory),
 		},
 		"timestamp": time.Now(),
 	})
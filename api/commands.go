@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runCommand dispatches command-line subcommands. It reports false when the
+// arguments do not name one, in which case the API server starts.
+func runCommand(args []string) (exitCode int, handled bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	switch args[0] {
+	case "import-factors":
+		return runImportFactors(args[1:]), true
+	case "migrate":
+		return runMigrate(args[1:]), true
+	}
+	return 0, false
+}
+
+// runImportFactors loads a published DEFRA or EPA dataset file:
+//
+//	carbonapi import-factors -dataset defra -file ghg-conversion-factors-2023-flat-format.csv
+//	carbonapi import-factors -dataset epa -year 2024 -file ghg-emission-factors-hub-2024.csv
+func runImportFactors(args []string) int {
+	flags := flag.NewFlagSet("import-factors", flag.ContinueOnError)
+	datasetName := flags.String("dataset", "", "dataset layout: defra or epa")
+	path := flags.String("file", "", "path to the CSV export")
+	year := flags.Int("year", 0, "dataset year (read from the file for DEFRA)")
+	skipDuplicates := flags.Bool("skip-duplicates", false, "skip factors that already exist instead of aborting")
+	dryRun := flags.Bool("dry-run", false, "parse and report without writing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	dataset, err := lookupFactorDataset(*datasetName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	file, err := os.Open(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open dataset: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	parsed, err := dataset.Parse(file, *year)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse %s dataset: %v\n", dataset.Name(), err)
+		return 1
+	}
+	for _, skipped := range parsed.Skipped {
+		fmt.Printf("skipped: %s\n", skipped)
+	}
+	fmt.Printf("Parsed %d factors from %s (version %s)\n", len(parsed.Factors), dataset.Name(), parsed.Version)
+	if *dryRun {
+		return 0
+	}
+
+	// Imports go to Postgres only; the in-memory store would discard them
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Database unavailable: %v\n", err)
+		return 1
+	}
+	checkSchema(db)
+	// Going through the factor cache tells running instances to drop theirs
+	cache := initRedis()
+	defer cache.Close()
+	cs := NewCarbonService(NewFactorCache(NewPostgresStore(db), cache), cache)
+	defer cs.store.Close()
+
+	result, err := cs.importEmissionFactors(parsed.Factors, *skipDuplicates)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		return 1
+	}
+	if len(result.Errors) > 0 {
+		for _, rowErr := range result.Errors {
+			fmt.Fprintf(os.Stderr, "row %d: %s\n", rowErr.Row, rowErr.Message)
+		}
+		fmt.Fprintln(os.Stderr, "Import rejected, no factors were written")
+		return 1
+	}
+
+	fmt.Printf("✅ Imported %d factors (%d skipped as duplicates)\n", result.Created, result.Skipped)
+	return 0
+}
+
+// runMigrate applies, rolls back or lists schema migrations:
+//
+//	carbonapi migrate up [-to 3]
+//	carbonapi migrate down [-steps 1]
+//	carbonapi migrate status
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: carbonapi migrate up|down|status")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	target := flags.Int("to", 0, "apply migrations up to and including this version (default all)")
+	steps := flags.Int("steps", 1, "number of migrations to roll back")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Database unavailable: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load migrations: %v\n", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx, *target)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		// Sample data needs the latest schema, and is only seeded into an
+		// empty table, so a partial migration must not seed it early
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read schema version: %v\n", err)
+			return 1
+		}
+		if len(pending) > 0 {
+			fmt.Printf("Skipping sample data until the remaining %d migrations are applied\n", len(pending))
+			break
+		}
+		insertSampleData(db)
+	case "down":
+		if *steps < 1 {
+			fmt.Fprintln(os.Stderr, "-steps must be at least 1")
+			return 2
+		}
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, migration := range reverted {
+			fmt.Printf("rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Rollback failed: %v\n", err)
+			return 1
+		}
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read schema version: %v\n", err)
+			return 1
+		}
+		for _, entry := range status {
+			applied := "pending"
+			if entry.AppliedAt != nil {
+				applied = entry.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-32s %s\n", entry.Version, entry.Name, applied)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n", args[0])
+		return 2
+	}
+	return 0
+}
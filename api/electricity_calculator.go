@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+func init() {
+	RegisterCalculator(electricityCalculator{})
+}
+
+// Scope 2 accounting methods of the GHG Protocol Scope 2 Guidance.
+const (
+	Scope2LocationBased = "location"
+	Scope2MarketBased   = "market"
+)
+
+// residualMixMode is the factor mode of a region's residual mix: the grid
+// with the renewable generation already claimed by certificates removed.
+const residualMixMode = "residual_mix"
+
+// EnergyCertificate is a contractual instrument covering part of the
+// electricity used, such as a REC, a Guarantee of Origin or a PPA.
+type EnergyCertificate struct {
+	Type string  `json:"type"`
+	KWh  float64 `json:"kwh"`
+	// EmissionFactor is the instrument's rate in kg CO2e per kWh; zero for
+	// renewable sources.
+	EmissionFactor float64 `json:"emission_factor,omitempty"`
+}
+
+// LoadInterval is the electricity used in one interval of a load profile.
+// Intervals are priced at the intensity of the hour they start in, so they
+// should not span more than an hour.
+type LoadInterval struct {
+	Start time.Time `json:"start"`
+	KWh   float64   `json:"kwh"`
+}
+
+// Scope2Result reports electricity under both Scope 2 methods. Method is
+// the one given as the calculation's carbon footprint.
+type Scope2Result struct {
+	Method        string  `json:"method"`
+	LocationBased float64 `json:"location_based"`
+	MarketBased   float64 `json:"market_based"`
+}
+
+// electricityCalculator covers purchased electricity by kWh. The location
+// based figure uses the grid factor of the region; the market based one
+// follows the Scope 2 Guidance hierarchy: certificates, then the supplier's
+// rate, then the residual mix, then the grid factor.
+type electricityCalculator struct{}
+
+func (electricityCalculator) Activity() string { return "electricity" }
+
+func (electricityCalculator) Describe() ActivityDescription {
+	return ActivityDescription{
+		Description: "Calculate location and market based Scope 2 emissions for electricity consumption",
+		OptionsKey:  "energy_sources",
+		Options:     defaultModes("electricity"),
+		Example: map[string]interface{}{
+			"activity":        "electricity",
+			"amount":          100,
+			"unit":            "kwh",
+			"transport":       "grid",
+			"country":         "DE",
+			"supplier_factor": 0.2,
+			"certificates":    []map[string]interface{}{{"type": "go", "kwh": 40}},
+		},
+	}
+}
+
+func (electricityCalculator) RequiredFields() []string {
+	return []string{"activity", "amount"}
+}
+
+func (electricityCalculator) Formula() string {
+	return "energy_kwh × emission_factor"
+}
+
+func (electricityCalculator) Validate(req CalculateRequest) error {
+	if len(req.LoadProfile) > 0 {
+		if err := validateLoadProfile(req); err != nil {
+			return err
+		}
+	} else if err := requirePositive("amount", req.Amount); err != nil {
+		return err
+	}
+	switch req.Scope2Method {
+	case "", Scope2LocationBased, Scope2MarketBased:
+	default:
+		return invalidField("scope2_method", "must be %q or %q", Scope2LocationBased, Scope2MarketBased)
+	}
+	if req.SupplierFactor != nil && *req.SupplierFactor < 0 {
+		return invalidField("supplier_factor", "must not be negative")
+	}
+
+	var covered float64
+	for i, certificate := range req.Certificates {
+		field := fmt.Sprintf("certificates[%d]", i)
+		if certificate.Type == "" {
+			return invalidField(field+".type", "is required")
+		}
+		if err := requirePositive(field+".kwh", certificate.KWh); err != nil {
+			return err
+		}
+		if certificate.EmissionFactor < 0 {
+			return invalidField(field+".emission_factor", "must not be negative")
+		}
+		covered += certificate.KWh
+	}
+	if consumed := electricityConsumption(req); covered > consumed {
+		return invalidField("certificates", "cover %g kWh, more than the %g kWh consumed", covered, consumed)
+	}
+	return nil
+}
+
+func validateLoadProfile(req CalculateRequest) error {
+	if len(req.LoadProfile) > maxLoadIntervals {
+		return invalidField("load_profile", "at most %d intervals are allowed", maxLoadIntervals)
+	}
+	first, last := req.LoadProfile[0].Start, req.LoadProfile[0].Start
+	for i, interval := range req.LoadProfile {
+		field := fmt.Sprintf("load_profile[%d]", i)
+		if interval.Start.IsZero() {
+			return invalidField(field+".start", "is required")
+		}
+		if interval.KWh < 0 {
+			return invalidField(field+".kwh", "must not be negative")
+		}
+		if interval.Start.Before(first) {
+			first = interval.Start
+		}
+		if interval.Start.After(last) {
+			last = interval.Start
+		}
+	}
+	if last.Sub(first) >= maxIntensitySpan {
+		return invalidField("load_profile", "must span less than 366 days")
+	}
+
+	total := electricityConsumption(req)
+	if total <= 0 {
+		return invalidField("load_profile", "must use some electricity")
+	}
+	if req.Amount != 0 && math.Abs(req.Amount-total) > 1e-6*total {
+		return invalidField("amount", "must equal the load profile total of %g kWh", total)
+	}
+	return nil
+}
+
+// electricityConsumption is the kWh of a request: the load profile total
+// when one is given.
+func electricityConsumption(req CalculateRequest) float64 {
+	if len(req.LoadProfile) == 0 {
+		return req.Amount
+	}
+	var total float64
+	for _, interval := range req.LoadProfile {
+		total += interval.KWh
+	}
+	return total
+}
+
+func (electricityCalculator) Calculate(factors FactorSource, req CalculateRequest) (CalculatorResult, error) {
+	source := firstNonEmpty(req.Transport, "grid")
+	region := requestRegion(req)
+
+	consumed := electricityConsumption(req)
+
+	factor, err := factors.EmissionFactor(FactorQuery{Activity: "electricity", Mode: source, Region: region})
+	if err != nil {
+		return CalculatorResult{}, err
+	}
+	locationBased := consumed * factor.Factor
+	locationGases := gasAmounts(factor, consumed)
+	location := map[string]interface{}{
+		"requested_region": firstNonEmpty(region, worldRegion),
+		"region":           factor.Region,
+		"region_level":     regionLevel(factor.Region),
+		"emission_factor":  factor.Factor,
+		"method":           "annual",
+	}
+	if len(req.LoadProfile) > 0 {
+		hourly, total, matched, err := hourlyFootprint(factors, region, req.LoadProfile, factor)
+		if err != nil {
+			return CalculatorResult{}, err
+		}
+		location["hourly"] = hourly
+		if matched > 0 {
+			locationBased = total
+			locationGases = nil
+			location["method"] = "hourly"
+		}
+	}
+	location["kg_co2e"] = roundTo(locationBased, 3)
+
+	// Market based: certificates first, then the supplier's rate, and the
+	// residual mix for whatever neither covers
+	var certificateKWh, certificateEmissions float64
+	gases := &GasAmounts{}
+	for _, certificate := range req.Certificates {
+		certificateKWh += certificate.KWh
+		certificateEmissions += certificate.KWh * certificate.EmissionFactor
+		if certificate.EmissionFactor != 0 {
+			gases = nil
+		}
+	}
+	remaining := consumed - certificateKWh
+
+	market := map[string]interface{}{
+		"certificates_kwh":     roundTo(certificateKWh, 3),
+		"certificates_kg_co2e": roundTo(certificateEmissions, 3),
+		"remaining_kwh":        roundTo(remaining, 3),
+	}
+	marketBased := certificateEmissions
+	switch {
+	case remaining <= 0:
+	case req.SupplierFactor != nil:
+		marketBased += remaining * *req.SupplierFactor
+		gases = nil
+		market["remaining_source"] = "supplier"
+		market["remaining_factor"] = *req.SupplierFactor
+	default:
+		residual, err := factors.EmissionFactor(FactorQuery{
+			Activity: "electricity",
+			Mode:     residualMixMode,
+			Region:   region,
+			Optional: true,
+		})
+		switch {
+		case err == nil:
+			market["remaining_source"] = residualMixMode
+			market["residual_mix_region"] = residual.Region
+			market["residual_mix_level"] = regionLevel(residual.Region)
+		case errors.Is(err, ErrNotFound):
+			// No residual mix published for the region; the Scope 2
+			// Guidance allows the grid factor instead
+			residual = factor
+			market["remaining_source"] = "grid"
+		default:
+			return CalculatorResult{}, err
+		}
+		marketBased += remaining * residual.Factor
+		gases = gases.plus(gasAmounts(residual, remaining))
+		market["remaining_factor"] = residual.Factor
+	}
+	market["kg_co2e"] = roundTo(marketBased, 3)
+
+	scope2 := &Scope2Result{
+		Method:        firstNonEmpty(req.Scope2Method, Scope2LocationBased),
+		LocationBased: roundTo(locationBased, 3),
+		MarketBased:   roundTo(marketBased, 3),
+	}
+	carbonFootprint := locationBased
+	if scope2.Method == Scope2MarketBased {
+		carbonFootprint = marketBased
+	} else {
+		gases = locationGases
+	}
+
+	breakdown := map[string]interface{}{
+		"energy_kwh":      consumed,
+		"energy_source":   source,
+		"emission_factor": factor.Factor,
+		"location_based":  location,
+		"market_based":    market,
+	}
+
+	return CalculatorResult{
+		CarbonFootprint: carbonFootprint,
+		Breakdown:       breakdown,
+		Gases:           gases,
+		Scope2:          scope2,
+	}, nil
+}
+
+// hourlyFootprint prices a load profile hour by hour. Hours without an
+// intensity use the annual factor and are counted as estimated.
+func hourlyFootprint(factors FactorSource, region string, profile []LoadInterval, annual EmissionFactor) (hourly map[string]interface{}, total float64, matched int, err error) {
+	kwhByHour := make(map[time.Time]float64)
+	var from, to time.Time
+	for _, interval := range profile {
+		hour := interval.Start.UTC().Truncate(time.Hour)
+		kwhByHour[hour] += interval.KWh
+		if from.IsZero() || hour.Before(from) {
+			from = hour
+		}
+		if hour.Add(time.Hour).After(to) {
+			to = hour.Add(time.Hour)
+		}
+	}
+
+	var series IntensitySeries
+	if source, ok := factors.(IntensitySource); ok {
+		if series, err = source.HourlyIntensity(region, from, to); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	intensity := make(map[time.Time]float64, len(series.Points))
+	for _, point := range series.Points {
+		intensity[point.Hour] = point.Intensity
+	}
+
+	var consumed float64
+	var estimated int
+	var peakHour time.Time
+	var peak float64
+	for hour, kwh := range kwhByHour {
+		rate, ok := intensity[hour]
+		if ok {
+			matched++
+		} else {
+			rate = annual.Factor
+			estimated++
+		}
+		emissions := kwh * rate
+		total += emissions
+		consumed += kwh
+		if emissions > peak || emissions == peak && (peakHour.IsZero() || hour.Before(peakHour)) {
+			peak, peakHour = emissions, hour
+		}
+	}
+
+	hourly = map[string]interface{}{
+		"intervals":            len(profile),
+		"hours":                len(kwhByHour),
+		"hours_with_intensity": matched,
+		"hours_estimated":      estimated,
+		"from":                 from,
+		"to":                   to,
+	}
+	if series.Region != "" {
+		hourly["region"] = series.Region
+		hourly["region_level"] = regionLevel(series.Region)
+	}
+	if consumed > 0 {
+		hourly["average_intensity"] = roundTo(total/consumed, 6)
+	}
+	if !peakHour.IsZero() {
+		hourly["peak_hour"] = map[string]interface{}{
+			"hour":    peakHour,
+			"kwh":     roundTo(kwhByHour[peakHour], 3),
+			"kg_co2e": roundTo(peak, 3),
+		}
+	}
+	return hourly, total, matched, nil
+}
+
+func (electricityCalculator) Suggestions(req CalculateRequest, carbonFootprint float64) []string {
+	if req.Transport != "" && req.Transport != "grid" {
+		return nil
+	}
+	return []string{
+		"Switch to renewable energy for 92% reduction",
+		"Install solar panels for clean energy",
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultFactorVersion labels the built-in fallback factors.
+const defaultFactorVersion = "builtin-2023"
+
+// worldRegion is the region of factors that apply globally.
+const worldRegion = "WORLD"
+
+// Date is a calendar date accepted as "2006-01-02" or RFC 3339 in requests.
+type Date struct {
+	time.Time
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("date must be a string: %w", err)
+	}
+	parsed, err := parseDate(value)
+	if err != nil {
+		return err
+	}
+	d.Time = parsed
+	return nil
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Format("2006-01-02"))
+}
+
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+}
+
+// FactorReference identifies the exact factor row a calculation used, so that
+// historical results can be reproduced after a dataset is superseded.
+type FactorReference struct {
+	ID            int    `json:"id"`
+	Activity      string `json:"activity"`
+	TransportMode string `json:"transport_mode"`
+	Region        string `json:"region,omitempty"`
+	Version       string `json:"version"`
+	Source        string `json:"source"`
+	// Scope is copied from the factor so results can be classified by it.
+	Scope          int `json:"scope,omitempty"`
+	Scope3Category int `json:"scope3_category,omitempty"`
+}
+
+// factorRecorder pins every lookup to the activity date of a request and
+// remembers which factors were used. Factors with a gas split have their
+// CO2e recomputed under the request's GWP set.
+type factorRecorder struct {
+	source FactorSource
+	at     time.Time
+	gwp    GWPSet
+	used   []FactorReference
+	// unsplit is set once a factor without a gas split was used, which
+	// keeps the GWPs it was published with.
+	unsplit bool
+}
+
+func (r *factorRecorder) EmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	if q.At.IsZero() {
+		q.At = r.at
+	}
+	factor, err := r.source.EmissionFactor(q)
+	if err != nil {
+		return factor, err
+	}
+	if factor.Gases != nil {
+		factor.Factor = roundTo(factor.Gases.co2e(r.gwp), 10)
+	} else {
+		r.unsplit = true
+	}
+	for _, ref := range r.used {
+		if ref.ID == factor.ID && ref.Activity == factor.Activity && ref.TransportMode == factor.TransportMode && ref.Region == factor.Region {
+			return factor, nil
+		}
+	}
+	r.used = append(r.used, FactorReference{
+		ID:             factor.ID,
+		Activity:       factor.Activity,
+		TransportMode:  factor.TransportMode,
+		Region:         factor.Region,
+		Version:        factor.Version,
+		Source:         factor.Source,
+		Scope:          factor.Scope,
+		Scope3Category: factor.Scope3Category,
+	})
+	return factor, nil
+}
+
+// HourlyIntensity passes hourly lookups through to the source, if it serves
+// them.
+func (r *factorRecorder) HourlyIntensity(region string, from, to time.Time) (IntensitySeries, error) {
+	source, ok := r.source.(IntensitySource)
+	if !ok {
+		return IntensitySeries{}, nil
+	}
+	series, err := source.HourlyIntensity(region, from, to)
+	if len(series.Points) > 0 {
+		// Hourly intensities are CO2e without a gas split
+		r.unsplit = true
+	}
+	return series, err
+}
+
+// emissionFactorColumns is the column list scanEmissionFactor expects.
+const emissionFactorColumns = `id, activity, transport_mode, region, factor, unit, source, version, valid_from, valid_to,
+	co2_factor, ch4_factor, n2o_factor, ghg_scope, scope3_category, uncertainty`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEmissionFactor(row rowScanner) (EmissionFactor, error) {
+	var factor EmissionFactor
+	var validFrom, validTo sql.NullTime
+	var co2, ch4, n2o sql.NullFloat64
+	var scope, category sql.NullInt64
+	var uncertainty []byte
+
+	err := row.Scan(
+		&factor.ID,
+		&factor.Activity,
+		&factor.TransportMode,
+		&factor.Region,
+		&factor.Factor,
+		&factor.Unit,
+		&factor.Source,
+		&factor.Version,
+		&validFrom,
+		&validTo,
+		&co2,
+		&ch4,
+		&n2o,
+		&scope,
+		&category,
+		&uncertainty,
+	)
+	if err != nil {
+		return factor, err
+	}
+
+	if validFrom.Valid {
+		factor.ValidFrom = &Date{validFrom.Time}
+	}
+	if validTo.Valid {
+		factor.ValidTo = &Date{validTo.Time}
+	}
+	factor.Scope = int(scope.Int64)
+	factor.Scope3Category = int(category.Int64)
+	if co2.Valid || ch4.Valid || n2o.Valid {
+		factor.Gases = &GasFactors{CO2: co2.Float64, CH4: ch4.Float64, N2O: n2o.Float64}
+	}
+	if uncertainty != nil {
+		factor.Uncertainty = &Uncertainty{}
+		if err := json.Unmarshal(uncertainty, factor.Uncertainty); err != nil {
+			return factor, fmt.Errorf("factor %d uncertainty: %w", factor.ID, err)
+		}
+	}
+	return factor, nil
+}
+
+// GasFactors splits a factor into the mass of each gas emitted per unit of
+// activity, in kg. Factor stays the CO2e total.
+type GasFactors struct {
+	CO2 float64 `json:"co2"`
+	CH4 float64 `json:"ch4"`
+	N2O float64 `json:"n2o"`
+}
+
+// scopeColumns returns the nullable column values for a factor's scope.
+func (f EmissionFactor) scopeColumns() (scope, category sql.NullInt64) {
+	return sql.NullInt64{Int64: int64(f.Scope), Valid: f.Scope != 0},
+		sql.NullInt64{Int64: int64(f.Scope3Category), Valid: f.Scope3Category != 0}
+}
+
+// uncertaintyColumn returns the JSONB value of a factor's uncertainty, nil
+// for NULL.
+func (f EmissionFactor) uncertaintyColumn() ([]byte, error) {
+	if f.Uncertainty == nil {
+		return nil, nil
+	}
+	return json.Marshal(f.Uncertainty)
+}
+
+// gasColumns returns the nullable column values for a factor's gas split.
+func (f EmissionFactor) gasColumns() (co2, ch4, n2o sql.NullFloat64) {
+	if f.Gases == nil {
+		return
+	}
+	return sql.NullFloat64{Float64: f.Gases.CO2, Valid: true},
+		sql.NullFloat64{Float64: f.Gases.CH4, Valid: true},
+		sql.NullFloat64{Float64: f.Gases.N2O, Valid: true}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFactorSelectionByDate(t *testing.T) {
+	day := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	create := func(version string, factor float64, from string, to string) int {
+		f := EmissionFactor{
+			Activity: "fuel", TransportMode: "diesel", Region: worldRegion, Factor: factor,
+			Unit: "kg_co2e_per_liter", Source: "test", Version: version, ValidFrom: &Date{day(from)},
+		}
+		if to != "" {
+			f.ValidTo = &Date{day(to)}
+		}
+		id, err := cs.store.CreateEmissionFactor(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// The seeded built-in factor (2.68) has no validity period and applies
+	// whenever no dated version does.
+	create("2022", 2.5, "2022-01-01", "2023-01-01")
+	create("2023", 2.6, "2023-01-01", "")
+	revised := create("2023-revised", 2.65, "2023-01-01", "")
+	if _, err := cs.store.RetireEmissionFactor(revised, day("2024-01-01")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at      string
+		factor  float64
+		version string
+	}{
+		{"2021-06-01", 2.68, defaultFactorVersion},
+		{"2022-01-01", 2.5, "2022"},
+		{"2022-12-31", 2.5, "2022"},
+		// Of two versions starting the same day the later one wins, until it
+		// is retired; valid_to is exclusive
+		{"2023-01-01", 2.65, "2023-revised"},
+		{"2023-12-31", 2.65, "2023-revised"},
+		{"2024-01-01", 2.6, "2023"},
+		{"2025-06-01", 2.6, "2023"},
+	}
+	for _, tt := range tests {
+		factor, err := cs.getEmissionFactor(FactorQuery{Activity: "fuel", Mode: "diesel", At: day(tt.at)})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.at, err)
+		}
+		if factor.Factor != tt.factor || factor.Version != tt.version {
+			t.Errorf("%s: factor %v version %q, want %v %q", tt.at, factor.Factor, factor.Version, tt.factor, tt.version)
+		}
+
+		response, err := cs.calculateCarbonFootprint(CalculateRequest{
+			Activity: "fuel", Amount: 10, Transport: "diesel", ActivityDate: &Date{day(tt.at)},
+		}, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.at, err)
+		}
+		if response.CarbonFootprint != roundTo(10*tt.factor, 3) || response.Factors[0].Version != tt.version {
+			t.Errorf("%s: calculated %v with version %q, want %v with %q",
+				tt.at, response.CarbonFootprint, response.Factors[0].Version, 10*tt.factor, tt.version)
+		}
+	}
+}
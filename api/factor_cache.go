@@ -0,0 +1,347 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	factorCacheSize     = 2048
+	factorLocalTTL      = time.Minute
+	factorRedisTTL      = time.Hour
+	factorGenerationKey = "factors:generation"
+	factorInvalidations = "factors:invalidate"
+)
+
+// FactorCache wraps a Storage with a two-level cache for factor reads: a
+// process-local LRU in front of Redis. Factor writes bump a generation
+// counter in Redis and announce it over pub/sub, so every instance drops
+// its local entries and stops reading the old Redis keys. Local misses
+// re-read the generation, so an announcement missed while the subscription
+// was down is caught up on; the short local TTL bounds staleness of local
+// hits and while Redis is unreachable.
+type FactorCache struct {
+	Storage
+	redis   *redis.Client
+	breaker redisBreaker
+
+	mu         sync.Mutex
+	local      *lruCache
+	generation int64
+	// epoch counts local purges so a load that raced one is not cached.
+	epoch int64
+	// pending is set while an invalidation has not reached Redis; Redis
+	// entries are bypassed until it has.
+	pending bool
+}
+
+func NewFactorCache(store Storage, client *redis.Client) *FactorCache {
+	fc := &FactorCache{
+		Storage: store,
+		redis:   client,
+		breaker: redisBreaker{name: "Factor cache"},
+		local:   newLRUCache(factorCacheSize),
+	}
+	if client != nil {
+		generation, err := client.Get(context.Background(), factorGenerationKey).Int64()
+		switch {
+		case err == nil:
+			fc.generation = generation
+		case !errors.Is(err, redis.Nil):
+			// Unknown generation: start a new one before trusting Redis
+			fc.pending = true
+		}
+	}
+	return fc
+}
+
+// cachedFactor is what is stored for a lookup. Found is false for queries
+// with no stored factor, which are cached too since the calculators then
+// fall back to the built-in defaults on every request.
+type cachedFactor struct {
+	Found  bool           `json:"found"`
+	Factor EmissionFactor `json:"factor"`
+}
+
+// Listen applies invalidations published by other instances until ctx ends.
+func (fc *FactorCache) Listen(ctx context.Context) {
+	if fc.redis == nil {
+		return
+	}
+	pubsub := fc.redis.Subscribe(ctx, factorInvalidations)
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		generation, err := strconv.ParseInt(msg.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+		fc.mu.Lock()
+		if generation > fc.generation {
+			fc.generation = generation
+		}
+		fc.purgeLocal()
+		fc.mu.Unlock()
+	}
+}
+
+// purgeLocal empties the LRU; callers hold fc.mu.
+func (fc *FactorCache) purgeLocal() {
+	fc.local.purge()
+	fc.epoch++
+}
+
+// invalidate drops every cached factor after a write.
+func (fc *FactorCache) invalidate() {
+	fc.mu.Lock()
+	fc.purgeLocal()
+	fc.pending = fc.redis != nil
+	fc.mu.Unlock()
+
+	fc.publishInvalidation()
+}
+
+// publishInvalidation moves every instance to a new generation of Redis
+// keys. If Redis is unreachable it is retried on a later read.
+func (fc *FactorCache) publishInvalidation() {
+	if fc.redis == nil || !fc.breaker.available() {
+		return
+	}
+	ctx := context.Background()
+	generation, err := fc.redis.Incr(ctx, factorGenerationKey).Result()
+	if err == nil {
+		err = fc.redis.Publish(ctx, factorInvalidations, generation).Err()
+	}
+	if err != nil {
+		fc.breaker.failed(err)
+		return
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.pending = false
+	if generation > fc.generation {
+		fc.generation = generation
+	}
+}
+
+// useRedis reports whether Redis entries can be trusted right now.
+func (fc *FactorCache) useRedis() bool {
+	if fc.redis == nil || !fc.breaker.available() {
+		return false
+	}
+	fc.mu.Lock()
+	pending := fc.pending
+	fc.mu.Unlock()
+	if pending {
+		fc.publishInvalidation()
+		fc.mu.Lock()
+		pending = fc.pending
+		fc.mu.Unlock()
+	}
+	return !pending
+}
+
+// get reads key through both cache levels, loading it with load on a miss.
+func (fc *FactorCache) get(key string, dest interface{}, load func() (interface{}, error)) error {
+	fc.mu.Lock()
+	generation, epoch := fc.generation, fc.epoch
+	data, ok := fc.local.get(key)
+	fc.mu.Unlock()
+	if ok {
+		return json.Unmarshal(data, dest)
+	}
+
+	ctx := context.Background()
+	useRedis := fc.useRedis()
+	if useRedis {
+		var err error
+		if generation, epoch, err = fc.syncGeneration(ctx); err != nil {
+			fc.breaker.failed(err)
+			useRedis = false
+		}
+	}
+	redisKey := "factors:" + strconv.FormatInt(generation, 10) + ":" + key
+	if useRedis {
+		data, err := fc.redis.Get(ctx, redisKey).Bytes()
+		if err == nil {
+			fc.storeLocal(epoch, key, data)
+			return json.Unmarshal(data, dest)
+		}
+		if !errors.Is(err, redis.Nil) {
+			fc.breaker.failed(err)
+		}
+	}
+
+	value, err := load()
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	fc.storeLocal(epoch, key, data)
+	if useRedis {
+		if err := fc.redis.Set(ctx, redisKey, data, factorRedisTTL).Err(); err != nil {
+			fc.breaker.failed(err)
+		}
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// syncGeneration reads the current generation from Redis, purging the LRU
+// if it moved on without this instance hearing about it.
+func (fc *FactorCache) syncGeneration(ctx context.Context) (generation, epoch int64, err error) {
+	generation, err = fc.redis.Get(ctx, factorGenerationKey).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, 0, err
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if generation > fc.generation {
+		fc.generation = generation
+		fc.purgeLocal()
+	}
+	return fc.generation, fc.epoch, nil
+}
+
+// storeLocal caches data unless an invalidation arrived while it was loaded.
+func (fc *FactorCache) storeLocal(epoch int64, key string, data []byte) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if epoch == fc.epoch {
+		fc.local.add(key, data, time.Now().Add(factorLocalTTL))
+	}
+}
+
+// Validity periods are whole dates, so lookups are cached per day.
+func cacheDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func (fc *FactorCache) FindEmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	key := "find:" + q.Activity + "|" + q.Mode + "|" + q.Region + "|" + cacheDate(q.At)
+
+	var cached cachedFactor
+	err := fc.get(key, &cached, func() (interface{}, error) {
+		factor, err := fc.Storage.FindEmissionFactor(q)
+		if errors.Is(err, ErrNotFound) {
+			return cachedFactor{}, nil
+		}
+		return cachedFactor{Found: true, Factor: factor}, err
+	})
+	if err != nil {
+		return EmissionFactor{}, err
+	}
+	if !cached.Found {
+		return EmissionFactor{}, ErrNotFound
+	}
+	return cached.Factor, nil
+}
+
+func (fc *FactorCache) ListEmissionFactors(at time.Time) ([]EmissionFactor, error) {
+	key := "list:all"
+	if !at.IsZero() {
+		key = "list:" + cacheDate(at)
+	}
+
+	var factors []EmissionFactor
+	err := fc.get(key, &factors, func() (interface{}, error) {
+		return fc.Storage.ListEmissionFactors(at)
+	})
+	return factors, err
+}
+
+func (fc *FactorCache) CreateEmissionFactor(factor EmissionFactor) (int, error) {
+	id, err := fc.Storage.CreateEmissionFactor(factor)
+	if err == nil {
+		fc.invalidate()
+	}
+	return id, err
+}
+
+func (fc *FactorCache) UpdateEmissionFactor(id int, factor EmissionFactor) error {
+	err := fc.Storage.UpdateEmissionFactor(id, factor)
+	if err == nil {
+		fc.invalidate()
+	}
+	return err
+}
+
+func (fc *FactorCache) RetireEmissionFactor(id int, at time.Time) (EmissionFactor, error) {
+	factor, err := fc.Storage.RetireEmissionFactor(id, at)
+	if err == nil {
+		fc.invalidate()
+	}
+	return factor, err
+}
+
+func (fc *FactorCache) ImportEmissionFactors(factors []EmissionFactor, skipDuplicates bool) (*FactorImportResult, error) {
+	result, err := fc.Storage.ImportEmissionFactors(factors, skipDuplicates)
+	if err == nil && result.Created > 0 {
+		fc.invalidate()
+	}
+	return result, err
+}
+
+// lruCache is a size-bounded cache of serialized values with expiry. It is
+// not safe for concurrent use; FactorCache guards it with its mutex.
+type lruCache struct {
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (l *lruCache) get(key string) ([]byte, bool) {
+	elem, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		l.order.Remove(elem)
+		delete(l.entries, key)
+		return nil, false
+	}
+	l.order.MoveToFront(elem)
+	return entry.data, true
+}
+
+func (l *lruCache) add(key string, data []byte, expires time.Time) {
+	if elem, ok := l.entries[key]; ok {
+		elem.Value = &lruEntry{key: key, data: data, expires: expires}
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, data: data, expires: expires})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (l *lruCache) purge() {
+	l.order.Init()
+	l.entries = make(map[string]*list.Element)
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GWPs the published datasets used to express per-gas CO2e (IPCC AR5,
+// 100-year). They convert DEFRA's per-gas CO2e back to gas mass and combine
+// EPA's gas masses into CO2e.
+const (
+	datasetGWPCH4 = 28.0
+	datasetGWPN2O = 265.0
+)
+
+// Unit conversions into the canonical units the calculators expect.
+const (
+	litresPerGallon = 3.785411784
+	kwhPerMMBtu     = 293.07107
+	kgPerPound      = 0.45359237
+)
+
+// FactorDataset parses a published emission factor file into factor rows.
+type FactorDataset interface {
+	Name() string
+	Parse(r io.Reader, year int) (*ParsedDataset, error)
+}
+
+// ParsedDataset is the result of parsing a dataset file.
+type ParsedDataset struct {
+	Factors []EmissionFactor `json:"-"`
+	Year    int              `json:"year"`
+	Version string           `json:"version"`
+	Skipped []string         `json:"skipped,omitempty"`
+}
+
+var factorDatasets = map[string]FactorDataset{
+	"defra": defraDataset{},
+	"epa":   epaDataset{},
+}
+
+func lookupFactorDataset(name string) (FactorDataset, error) {
+	dataset, exists := factorDatasets[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("unknown dataset %q, expected defra or epa", name)
+	}
+	return dataset, nil
+}
+
+func readCSVRows(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	return reader.ReadAll()
+}
+
+func parseDatasetNumber(value string) (float64, bool) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", "")
+	if value == "" || value == "-" {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	return number, err == nil
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns dataset labels into activity/mode identifiers, capped to the
+// transport_mode column width.
+func slugify(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		slug := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(part), "_"), "_")
+		if slug != "" {
+			kept = append(kept, slug)
+		}
+	}
+	slug := strings.Join(kept, "_")
+	if len(slug) > 100 {
+		slug = strings.TrimRight(slug[:100], "_")
+	}
+	return slug
+}
+
+func yearStart(year int) *Date {
+	return &Date{time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// defraDataset reads the "flat file" layout of the UK DEFRA/DESNZ GHG
+// conversion factors: one row per gas with Level 1-4, Column Text, UOM,
+// GHG/Unit and a "GHG Conversion Factor <year>" column.
+type defraDataset struct{}
+
+func (defraDataset) Name() string { return "DEFRA" }
+
+// defraMapping assigns a DEFRA row to one of our calculators. Empty fields
+// match anything; comparisons are case-insensitive.
+type defraMapping struct {
+	level1, level2, level3, level4, columnText, uom string
+	activity, mode                                  string
+}
+
+var defraMappings = []defraMapping{
+	{level1: "Fuels", level3: "Diesel (average biofuel blend)", uom: "litres", activity: "fuel", mode: "diesel"},
+	{level1: "Fuels", level3: "Petrol (average biofuel blend)", uom: "litres", activity: "fuel", mode: "gasoline"},
+	{level1: "Fuels", level3: "Natural gas", uom: "kWh (Gross CV)", activity: "fuel", mode: "natural_gas"},
+	{level1: "UK electricity", level3: "Electricity: UK", uom: "kWh", activity: "electricity", mode: "grid"},
+	{level1: "Freighting goods", level2: "Freight flights", level3: "International, to/from non-UK", level4: "Average", columnText: "Without RF", uom: "tonne.km", activity: "shipping", mode: "air"},
+	{level1: "Freighting goods", level2: "HGV (all diesel)", level3: "All HGVs", columnText: "Average laden", uom: "tonne.km", activity: "shipping", mode: "road"},
+	{level1: "Freighting goods", level2: "Freight train", uom: "tonne.km", activity: "shipping", mode: "rail"},
+	{level1: "Freighting goods", level2: "Cargo ship", level3: "Container ship", level4: "Average", uom: "tonne.km", activity: "shipping", mode: "sea"},
+	{level1: "Business travel- air", level3: "Domestic, to/from UK", level4: "Average passenger", columnText: "Without RF", uom: "passenger.km", activity: "flight", mode: "domestic"},
+	{level1: "Business travel- air", level3: "Short-haul, to/from UK", level4: "Economy class", columnText: "Without RF", uom: "passenger.km", activity: "flight", mode: "short_haul_economy"},
+	{level1: "Business travel- air", level3: "Short-haul, to/from UK", level4: "Business class", columnText: "Without RF", uom: "passenger.km", activity: "flight", mode: "short_haul_business"},
+	{level1: "Business travel- air", level3: "Long-haul, to/from UK", level4: "Economy class", columnText: "Without RF", uom: "passenger.km", activity: "flight", mode: "long_haul_economy"},
+	{level1: "Business travel- air", level3: "Long-haul, to/from UK", level4: "Premium economy class", columnText: "Without RF", uom: "passenger.km", activity: "flight", mode: "long_haul_premium_economy"},
+	{level1: "Business travel- air", level3: "Long-haul, to/from UK", level4: "Business class", columnText: "Without RF", uom: "passenger.km", activity: "flight", mode: "long_haul_business"},
+	{level1: "Business travel- air", level3: "Long-haul, to/from UK", level4: "First class", columnText: "Without RF", uom: "passenger.km", activity: "flight", mode: "long_haul_first"},
+}
+
+func (m defraMapping) matches(row defraRow) bool {
+	pairs := [][2]string{
+		{m.level1, row.level1}, {m.level2, row.level2}, {m.level3, row.level3},
+		{m.level4, row.level4}, {m.columnText, row.columnText}, {m.uom, row.uom},
+	}
+	for _, pair := range pairs {
+		if pair[0] != "" && !strings.EqualFold(pair[0], pair[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// defraUnits maps DEFRA units of measure onto factor units. Rows in other
+// units are skipped and reported.
+var defraUnits = map[string]string{
+	"litres":         "kg_co2e_per_liter",
+	"kwh":            "kg_co2e_per_kwh",
+	"kwh (gross cv)": "kg_co2e_per_kwh",
+	"kwh (net cv)":   "kg_co2e_per_kwh",
+	"tonnes":         "kg_co2e_per_tonne",
+	"tonne.km":       "kg_co2e_per_tonne_km",
+	"passenger.km":   "kg_co2e_per_pkm",
+	"km":             "kg_co2e_per_km",
+	"miles":          "kg_co2e_per_mile",
+	"cubic metres":   "kg_co2e_per_m3",
+	"kg":             "kg_co2e_per_kg",
+	"gj":             "kg_co2e_per_gj",
+}
+
+type defraRow struct {
+	level1, level2, level3, level4, columnText, uom string
+}
+
+func (r defraRow) label() string {
+	return strings.Join([]string{r.level1, r.level2, r.level3, r.level4, r.columnText, r.uom}, " > ")
+}
+
+var defraYearHeader = regexp.MustCompile(`(?i)^GHG Conversion Factor\s+(\d{4})$`)
+
+func (d defraDataset) Parse(r io.Reader, year int) (*ParsedDataset, error) {
+	rows, err := readCSVRows(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading DEFRA flat file: %w", err)
+	}
+
+	// The published file has title rows above the header
+	columns := map[string]int{}
+	headerRow := -1
+	for i, row := range rows {
+		for j, cell := range row {
+			cell = strings.TrimSpace(cell)
+			if m := defraYearHeader.FindStringSubmatch(cell); m != nil {
+				columns["factor"] = j
+				if year == 0 {
+					year, _ = strconv.Atoi(m[1])
+				}
+			} else {
+				columns[strings.ToLower(cell)] = j
+			}
+		}
+		if _, ok := columns["factor"]; ok {
+			headerRow = i
+			break
+		}
+		columns = map[string]int{}
+	}
+	for _, required := range []string{"level 1", "level 2", "level 3", "level 4", "column text", "uom", "ghg/unit"} {
+		if _, ok := columns[required]; !ok || headerRow < 0 {
+			return nil, fmt.Errorf("not a DEFRA flat file: missing %q column", required)
+		}
+	}
+	if year == 0 {
+		return nil, fmt.Errorf("dataset year could not be determined, pass it explicitly")
+	}
+
+	cell := func(row []string, name string) string {
+		if idx := columns[name]; idx < len(row) {
+			return strings.TrimSpace(row[idx])
+		}
+		return ""
+	}
+
+	type entry struct {
+		row   defraRow
+		total float64
+		gases GasFactors
+		split bool // any per-gas row seen
+		has   bool
+	}
+	var order []string
+	entries := map[string]*entry{}
+
+	for _, raw := range rows[headerRow+1:] {
+		row := defraRow{
+			level1:     cell(raw, "level 1"),
+			level2:     cell(raw, "level 2"),
+			level3:     cell(raw, "level 3"),
+			level4:     cell(raw, "level 4"),
+			columnText: cell(raw, "column text"),
+			uom:        cell(raw, "uom"),
+		}
+		value, ok := parseDatasetNumber(cell(raw, "factor"))
+		if row.level1 == "" || !ok {
+			continue
+		}
+
+		key := row.label()
+		e, exists := entries[key]
+		if !exists {
+			e = &entry{row: row}
+			entries[key] = e
+			order = append(order, key)
+		}
+
+		// Per-gas rows are expressed as CO2e; store the gas mass instead
+		switch ghg := strings.ToLower(cell(raw, "ghg/unit")); {
+		case strings.Contains(ghg, "of co2 "):
+			e.gases.CO2 = value
+			e.split = true
+		case strings.Contains(ghg, "of ch4 "):
+			e.gases.CH4 = value / datasetGWPCH4
+			e.split = true
+		case strings.Contains(ghg, "of n2o "):
+			e.gases.N2O = value / datasetGWPN2O
+			e.split = true
+		case ghg == "kg co2e":
+			e.total = value
+			e.has = true
+		}
+	}
+
+	result := &ParsedDataset{Year: year, Version: fmt.Sprintf("DEFRA-%d", year)}
+	for _, key := range order {
+		e := entries[key]
+		if !e.has {
+			continue
+		}
+		unit, known := defraUnits[strings.ToLower(e.row.uom)]
+		if !known {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: unsupported unit", key))
+			continue
+		}
+
+		// Unmapped rows keep DEFRA's hierarchy so the whole year loads; the
+		// unit is part of the mode because DEFRA repeats rows per unit.
+		activity := slugify(e.row.level1)
+		mode := slugify(e.row.level2, e.row.level3, e.row.level4, e.row.columnText, e.row.uom)
+		for _, m := range defraMappings {
+			if m.matches(e.row) {
+				activity, mode = m.activity, m.mode
+				break
+			}
+		}
+
+		// Rows published only as CO2e have no split; an empty one would
+		// recompute the factor as zero
+		var gases *GasFactors
+		if e.split {
+			split := e.gases
+			gases = &split
+		}
+		result.Factors = append(result.Factors, EmissionFactor{
+			Activity:      activity,
+			TransportMode: mode,
+			Region:        "GB",
+			Factor:        e.total,
+			Unit:          unit,
+			Source:        fmt.Sprintf("DEFRA %d", year),
+			Version:       result.Version,
+			ValidFrom:     yearStart(year),
+			Gases:         gases,
+		})
+	}
+
+	return result, nil
+}
+
+// epaDataset reads tables of the US EPA GHG Emission Factors Hub exported to
+// CSV. Stationary combustion (Table 1), mobile combustion CO2 (Table 2) and
+// eGRID electricity (Table 6) are recognised by their headers; a sheet with
+// several tables stacked vertically is fine.
+type epaDataset struct{}
+
+func (epaDataset) Name() string { return "EPA" }
+
+// EPA fuel names mapped onto our fuel calculator's modes, per table since
+// the fuel calculator expects natural gas in kWh but liquid fuels in litres.
+var (
+	epaStationaryModes = map[string]string{"natural gas": "natural_gas"}
+	epaMobileModes     = map[string]string{"motor gasoline": "gasoline", "diesel fuel": "diesel"}
+)
+
+type epaTable int
+
+const (
+	epaNone epaTable = iota
+	epaStationary
+	epaMobile
+	epaElectricity
+)
+
+// findColumn returns the first header containing every fragment.
+func findColumn(header []string, fragments ...string) int {
+	for i, cell := range header {
+		cell = strings.ToLower(strings.Join(strings.Fields(cell), " "))
+		matched := true
+		for _, fragment := range fragments {
+			if !strings.Contains(cell, fragment) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e epaDataset) Parse(r io.Reader, year int) (*ParsedDataset, error) {
+	if year == 0 {
+		return nil, fmt.Errorf("the EPA hub does not state its year in the table, pass it explicitly")
+	}
+	rows, err := readCSVRows(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading EPA hub export: %w", err)
+	}
+
+	result := &ParsedDataset{Year: year, Version: fmt.Sprintf("EPA-%d", year)}
+	newFactor := func(activity, mode, region, unit string, gases GasFactors) EmissionFactor {
+		return EmissionFactor{
+			Activity:      activity,
+			TransportMode: mode,
+			Region:        region,
+			Factor:        gases.co2e(gwpSets[defaultGWPSet]),
+			Unit:          unit,
+			Source:        fmt.Sprintf("EPA GHG Emission Factors Hub %d", year),
+			Version:       result.Version,
+			ValidFrom:     yearStart(year),
+			Gases:         &gases,
+		}
+	}
+	fuelMode := func(modes map[string]string, name, suffix string) string {
+		if mode, ok := modes[strings.ToLower(name)]; ok {
+			return mode
+		}
+		return slugify(name, suffix)
+	}
+
+	table := epaNone
+	var cols map[string]int
+	for _, row := range rows {
+		// A recognised header starts a new table
+		switch {
+		case findColumn(row, "co2 factor", "mmbtu") >= 0 && findColumn(row, "fuel type") >= 0:
+			table = epaStationary
+			cols = map[string]int{
+				"fuel": findColumn(row, "fuel type"),
+				"co2":  findColumn(row, "co2 factor", "mmbtu"),
+				"ch4":  findColumn(row, "ch4 factor", "mmbtu"),
+				"n2o":  findColumn(row, "n2o factor", "mmbtu"),
+			}
+			continue
+		case findColumn(row, "kg co2 per unit") >= 0:
+			table = epaMobile
+			cols = map[string]int{
+				"fuel": findColumn(row, "fuel type"),
+				"co2":  findColumn(row, "kg co2 per unit"),
+				"unit": -1,
+			}
+			for i, cell := range row {
+				if strings.EqualFold(strings.TrimSpace(cell), "unit") {
+					cols["unit"] = i
+				}
+			}
+			continue
+		case findColumn(row, "egrid subregion acronym") >= 0:
+			table = epaElectricity
+			cols = map[string]int{
+				"region": findColumn(row, "egrid subregion acronym"),
+				"co2":    findColumn(row, "co2", "mwh"),
+				"ch4":    findColumn(row, "ch4", "mwh"),
+				"n2o":    findColumn(row, "n2o", "mwh"),
+			}
+			continue
+		}
+
+		get := func(name string) string {
+			if idx, ok := cols[name]; ok && idx >= 0 && idx < len(row) {
+				return strings.TrimSpace(row[idx])
+			}
+			return ""
+		}
+
+		switch table {
+		case epaStationary:
+			co2, ok := parseDatasetNumber(get("co2"))
+			if get("fuel") == "" || !ok {
+				continue
+			}
+			ch4, _ := parseDatasetNumber(get("ch4"))
+			n2o, _ := parseDatasetNumber(get("n2o"))
+			// kg CO2, g CH4 and g N2O per mmBtu, stored per kWh
+			result.Factors = append(result.Factors, newFactor("fuel", fuelMode(epaStationaryModes, get("fuel"), "stationary"), "US", "kg_co2e_per_kwh", GasFactors{
+				CO2: co2 / kwhPerMMBtu,
+				CH4: ch4 / 1000 / kwhPerMMBtu,
+				N2O: n2o / 1000 / kwhPerMMBtu,
+			}))
+
+		case epaMobile:
+			co2, ok := parseDatasetNumber(get("co2"))
+			if get("fuel") == "" || !ok {
+				continue
+			}
+			switch unit := strings.ToLower(get("unit")); unit {
+			case "gallon", "gallons":
+				result.Factors = append(result.Factors, newFactor("fuel", fuelMode(epaMobileModes, get("fuel"), "mobile"), "US", "kg_co2e_per_liter",
+					GasFactors{CO2: co2 / litresPerGallon}))
+			case "scf":
+				result.Factors = append(result.Factors, newFactor("fuel", fuelMode(nil, get("fuel"), "mobile_scf"), "US", "kg_co2e_per_unit",
+					GasFactors{CO2: co2}))
+			default:
+				result.Skipped = append(result.Skipped, fmt.Sprintf("mobile %s: unsupported unit %q", get("fuel"), unit))
+			}
+
+		case epaElectricity:
+			co2, ok := parseDatasetNumber(get("co2"))
+			region := strings.ToUpper(get("region"))
+			if region == "" || !ok {
+				continue
+			}
+			ch4, _ := parseDatasetNumber(get("ch4"))
+			n2o, _ := parseDatasetNumber(get("n2o"))
+			// lb per MWh, stored as kg per kWh
+			perKWh := kgPerPound / 1000
+			result.Factors = append(result.Factors, newFactor("electricity", "grid", "US-"+region, "kg_co2e_per_kwh", GasFactors{
+				CO2: co2 * perKWh,
+				CH4: ch4 * perKWh,
+				N2O: n2o * perKWh,
+			}))
+		}
+	}
+
+	if len(result.Factors) == 0 {
+		return nil, fmt.Errorf("no recognised EPA hub tables found")
+	}
+
+	// Tables 1 and 2 both cover some fuels; keep the first per key
+	seen := map[string]bool{}
+	deduped := result.Factors[:0]
+	for _, factor := range result.Factors {
+		key := factorKey(factor)
+		if seen[key] {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s/%s: duplicate in file", factor.Activity, factor.TransportMode))
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, factor)
+	}
+	result.Factors = deduped
+
+	return result, nil
+}
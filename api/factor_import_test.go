@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const defraSample = `UK Government GHG Conversion Factors for Company Reporting
+Level 1,Level 2,Level 3,Level 4,Column Text,UOM,GHG/Unit,GHG Conversion Factor 2024
+Fuels,Liquid fuels,Diesel (average biofuel blend),,,litres,kg CO2e,2.5
+Fuels,Liquid fuels,Diesel (average biofuel blend),,,litres,kg CO2e of CO2 per unit,2.4
+Fuels,Liquid fuels,Diesel (average biofuel blend),,,litres,kg CO2e of CH4 per unit,0.028
+Fuels,Liquid fuels,Diesel (average biofuel blend),,,litres,kg CO2e of N2O per unit,0.072
+Business travel- air,Flights,"Long-haul, to/from UK",Economy class,Without RF,passenger.km,kg CO2e,0.1
+`
+
+// Imported DEFRA factors are stored for GB and must win over the built-in
+// WORLD factors for requests from GB, and only for those.
+func TestImportedFactorsApplyToTheirRegion(t *testing.T) {
+	parsed, err := defraDataset{}.Parse(strings.NewReader(defraSample), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	result, err := cs.importEmissionFactors(parsed.Factors, false)
+	if err != nil || len(result.Errors) > 0 {
+		t.Fatalf("import: %v %v", err, result.Errors)
+	}
+
+	tests := []struct {
+		name string
+		req  CalculateRequest
+		want float64
+	}{
+		{"fuel in GB", CalculateRequest{Activity: "fuel", Amount: 100, Transport: "diesel", Region: "GB"}, 250},
+		{"fuel in GB subregion", CalculateRequest{Activity: "fuel", Amount: 100, Transport: "diesel", Country: "UK", Region: "SCT"}, 250},
+		{"fuel elsewhere", CalculateRequest{Activity: "fuel", Amount: 100, Transport: "diesel", Region: "FR"}, 268},
+		{"fuel without region", CalculateRequest{Activity: "fuel", Amount: 100, Transport: "diesel"}, 268},
+		{"flight in GB", CalculateRequest{Activity: "flight", From: "LHR", To: "JFK", Region: "GB"}, 598.322},
+		{"flight without region", CalculateRequest{Activity: "flight", From: "LHR", To: "JFK"}, 463.999},
+	}
+	for _, tt := range tests {
+		response, err := cs.calculateCarbonFootprint(tt.req, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if response.CarbonFootprint != tt.want {
+			t.Errorf("%s: footprint = %v, want %v", tt.name, response.CarbonFootprint, tt.want)
+		}
+	}
+}
+
+// With skip_duplicates a row repeating an earlier row of the same import is
+// skipped like one repeating a stored factor.
+func TestImportSkipsDuplicatesWithinBatch(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	paper := EmissionFactor{Activity: "paper", Factor: 0.9, Unit: "kg_co2e_per_kg", Source: "test", Version: "2024"}
+	stored := EmissionFactor{Activity: "fuel", TransportMode: "diesel", Factor: 2.68, Unit: "kg_co2e_per_liter", Source: "test", Version: defaultFactorVersion}
+
+	result, err := cs.importEmissionFactors([]EmissionFactor{paper, paper, stored}, true)
+	if err != nil || len(result.Errors) > 0 {
+		t.Fatalf("import: %v %v", err, result.Errors)
+	}
+	if result.Created != 1 || result.Skipped != 2 || len(result.IDs) != 1 {
+		t.Errorf("created %d, skipped %d, ids %v; want 1 created, 2 skipped", result.Created, result.Skipped, result.IDs)
+	}
+
+	matches := 0
+	for _, factor := range cs.store.(*MemoryStore).factors {
+		if factor.Activity == "paper" && factor.Version == "2024" {
+			matches++
+		}
+	}
+	if matches != 1 {
+		t.Errorf("%d paper factors stored, want 1", matches)
+	}
+}
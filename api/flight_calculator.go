@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+)
+
+func init() {
+	RegisterCalculator(flightCalculator{})
+}
+
+// longHaulThresholdKm separates short- and long-haul flights.
+const longHaulThresholdKm = 3700.0
+
+// defraDomesticCountry is where DEFRA's domestic flight factor applies.
+const defraDomesticCountry = "GB"
+
+// flightDistanceUncertainty is the range of the distance flown around the
+// uplifted great-circle estimate, from holding patterns and routing.
+var flightDistanceUncertainty = Uncertainty{Distribution: DistributionTriangular, Low: 0.95, High: 1.15}
+
+var cabinClasses = []string{"economy", "premium_economy", "business", "first"}
+
+// flightCalculator covers passenger air travel between IATA airports.
+type flightCalculator struct{}
+
+func (flightCalculator) Activity() string { return "flight" }
+
+func (flightCalculator) Describe() ActivityDescription {
+	return ActivityDescription{
+		Description: "Calculate carbon footprint for passenger air travel",
+		OptionsKey:  "cabin_classes",
+		Options:     cabinClasses,
+		Example: map[string]interface{}{
+			"activity":          "flight",
+			"from":              "JFK",
+			"to":                "LHR",
+			"passengers":        2,
+			"cabin_class":       "economy",
+			"round_trip":        true,
+			"radiative_forcing": 1.9,
+		},
+	}
+}
+
+func (flightCalculator) RequiredFields() []string {
+	return []string{"activity", "from", "to"}
+}
+
+func (flightCalculator) Formula() string {
+	return "great_circle_km × uplift × trips × emission_factor × radiative_forcing × passengers"
+}
+
+func (flightCalculator) Validate(req CalculateRequest) error {
+	if req.From == "" || req.To == "" {
+		return invalidField("from", "origin and destination IATA codes are required")
+	}
+	if _, ok := lookupAirport(req.From); !ok {
+		return invalidField("from", "unknown IATA airport code %q", req.From)
+	}
+	if _, ok := lookupAirport(req.To); !ok {
+		return invalidField("to", "unknown IATA airport code %q", req.To)
+	}
+	if strings.EqualFold(req.From, req.To) {
+		return invalidField("to", "destination must differ from origin")
+	}
+	if req.Passengers < 0 {
+		return invalidField("passengers", "must not be negative")
+	}
+	if req.CabinClass != "" && !containsString(cabinClasses, req.CabinClass) {
+		return invalidField("cabin_class", "must be one of %s", strings.Join(cabinClasses, ", "))
+	}
+	if req.RadiativeForcing != 0 && req.RadiativeForcing < 1 {
+		return invalidField("radiative_forcing", "multiplier must be at least 1")
+	}
+	return nil
+}
+
+func (flightCalculator) Calculate(factors FactorSource, req CalculateRequest) (CalculatorResult, error) {
+	origin, _ := lookupAirport(req.From)
+	destination, _ := lookupAirport(req.To)
+
+	passengers := req.Passengers
+	if passengers == 0 {
+		passengers = 1
+	}
+	cabinClass := req.CabinClass
+	if cabinClass == "" {
+		cabinClass = "economy"
+	}
+	radiativeForcing := req.RadiativeForcing
+	if radiativeForcing == 0 {
+		radiativeForcing = 1
+	}
+	trips := 1
+	if req.RoundTrip {
+		trips = 2
+	}
+
+	// Uplift corrects great-circle distance for routing and stacking
+	uplift := upliftFor("passenger_air")
+	greatCircle := greatCircleKm(origin.Location, destination.Location)
+	distance := sampleEstimate(factors, "distance", uplift.apply(greatCircle), &flightDistanceUncertainty)
+
+	// DEFRA's domestic factor is for flights within the UK; other flights,
+	// domestic or not, use its international short and long haul factors
+	haul := "long_haul"
+	switch {
+	case origin.Country == defraDomesticCountry && destination.Country == defraDomesticCountry:
+		haul = "domestic"
+	case greatCircle < longHaulThresholdKm:
+		haul = "short_haul"
+	}
+
+	factor, err := factors.EmissionFactor(FactorQuery{
+		Activity: "flight",
+		Mode:     flightFactorMode(haul, cabinClass),
+		Region:   requestRegion(req),
+	})
+	if err != nil {
+		return CalculatorResult{}, err
+	}
+
+	perPassenger := distance * float64(trips) * factor.Factor * radiativeForcing
+	total := perPassenger * float64(passengers)
+
+	breakdown := map[string]interface{}{
+		"origin":                origin,
+		"destination":           destination,
+		"great_circle_km":       roundTo(greatCircle, 1),
+		"distance_uplift":       uplift.Factor,
+		"distance_km":           roundTo(distance, 1),
+		"trips":                 trips,
+		"haul":                  haul,
+		"cabin_class":           cabinClass,
+		"passengers":            passengers,
+		"emission_factor":       factor.Factor,
+		"factor_mode":           factor.TransportMode,
+		"radiative_forcing":     radiativeForcing,
+		"per_passenger_kg_co2e": roundTo(perPassenger, 3),
+		"total_kg_co2e":         roundTo(total, 3),
+	}
+
+	// The gases are what the aircraft burns; radiative forcing adds warming
+	// from contrails and NOx that no gas here accounts for.
+	return CalculatorResult{
+		CarbonFootprint: total,
+		Breakdown:       breakdown,
+		Gases:           gasAmounts(factor, distance*float64(trips*passengers)),
+	}, nil
+}
+
+func (flightCalculator) Suggestions(req CalculateRequest, carbonFootprint float64) []string {
+	var suggestions []string
+	if req.CabinClass == "business" || req.CabinClass == "first" {
+		suggestions = append(suggestions, "Flying economy cuts per-passenger emissions by roughly two thirds on long-haul routes")
+	}
+	if origin, ok := lookupAirport(req.From); ok {
+		if destination, ok := lookupAirport(req.To); ok && greatCircleKm(origin.Location, destination.Location) < 700 {
+			suggestions = append(suggestions, "Consider rail for journeys under 700 km to cut emissions by up to 90%")
+		}
+	}
+	return suggestions
+}
+
+// flightFactorMode maps a haul and cabin class onto the factor table. DEFRA
+// publishes a single domestic average and no premium/first split on short haul.
+func flightFactorMode(haul, cabinClass string) string {
+	switch haul {
+	case "domestic":
+		return "domestic"
+	case "short_haul":
+		if cabinClass == "business" || cabinClass == "first" {
+			return "short_haul_business"
+		}
+		return "short_haul_economy"
+	}
+	return "long_haul_" + cabinClass
+}
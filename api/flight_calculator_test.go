@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+// builtinFactors serves the built-in factors, so calculators can be tested
+// without a store.
+type builtinFactors struct{}
+
+func (builtinFactors) EmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	factor, ok := (&CarbonService{}).getDefaultEmissionFactor(q.Activity, q.Mode)
+	if !ok {
+		return EmissionFactor{}, ErrNotFound
+	}
+	return factor, nil
+}
+
+func TestFlightHaul(t *testing.T) {
+	tests := []struct {
+		from, to string
+		cabin    string
+		haul     string
+		mode     string
+	}{
+		{"LHR", "EDI", "", "domestic", "domestic"},
+		{"MAN", "LGW", "business", "domestic", "domestic"},
+		{"JFK", "LAX", "", "long_haul", "long_haul_economy"},
+		{"JFK", "ATL", "", "short_haul", "short_haul_economy"},
+		{"FRA", "MUC", "", "short_haul", "short_haul_economy"},
+		{"LHR", "CDG", "first", "short_haul", "short_haul_business"},
+		{"LHR", "JFK", "", "long_haul", "long_haul_economy"},
+		{"SYD", "PER", "premium_economy", "short_haul", "short_haul_economy"},
+		{"CDG", "SIN", "business", "long_haul", "long_haul_business"},
+	}
+
+	for _, tt := range tests {
+		req := CalculateRequest{Activity: "flight", From: tt.from, To: tt.to, CabinClass: tt.cabin}
+		if err := (flightCalculator{}).Validate(req); err != nil {
+			t.Fatalf("%s-%s: Validate: %v", tt.from, tt.to, err)
+		}
+		result, err := flightCalculator{}.Calculate(builtinFactors{}, req)
+		if err != nil {
+			t.Fatalf("%s-%s: Calculate: %v", tt.from, tt.to, err)
+		}
+		if haul := result.Breakdown["haul"]; haul != tt.haul {
+			t.Errorf("%s-%s: haul = %v, want %s", tt.from, tt.to, haul, tt.haul)
+		}
+		if mode := result.Breakdown["factor_mode"]; mode != tt.mode {
+			t.Errorf("%s-%s: factor mode = %v, want %s", tt.from, tt.to, mode, tt.mode)
+		}
+	}
+}
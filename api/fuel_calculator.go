@@ -0,0 +1,72 @@
+package main
+
+func init() {
+	RegisterCalculator(fuelCalculator{})
+}
+
+// fuelCalculator covers direct combustion of fuels.
+type fuelCalculator struct{}
+
+func (fuelCalculator) Activity() string { return "fuel" }
+
+func (fuelCalculator) Describe() ActivityDescription {
+	return ActivityDescription{
+		Description: "Calculate carbon footprint for fuel consumption",
+		OptionsKey:  "fuel_types",
+		Options:     defaultModes("fuel"),
+		Example: map[string]interface{}{
+			"activity":  "fuel",
+			"amount":    50,
+			"unit":      "liters",
+			"transport": "gasoline",
+		},
+	}
+}
+
+func (fuelCalculator) RequiredFields() []string {
+	return []string{"activity", "amount", "transport"}
+}
+
+func (fuelCalculator) Formula() string {
+	return "fuel_liters × emission_factor"
+}
+
+func (fuelCalculator) Validate(req CalculateRequest) error {
+	if err := requirePositive("amount", req.Amount); err != nil {
+		return err
+	}
+	if req.Transport == "" {
+		return invalidField("transport", "is required")
+	}
+	return nil
+}
+
+func (fuelCalculator) Calculate(factors FactorSource, req CalculateRequest) (CalculatorResult, error) {
+	factor, err := factors.EmissionFactor(FactorQuery{Activity: "fuel", Mode: req.Transport, Region: requestRegion(req)})
+	if err != nil {
+		return CalculatorResult{}, err
+	}
+
+	// Calculate: amount (liters) × emission factor
+	carbonFootprint := req.Amount * factor.Factor
+
+	breakdown := map[string]interface{}{
+		"fuel_liters":     req.Amount,
+		"fuel_type":       req.Transport,
+		"emission_factor": factor.Factor,
+		"combustion":      "direct_emissions",
+	}
+
+	return CalculatorResult{
+		CarbonFootprint: carbonFootprint,
+		Breakdown:       breakdown,
+		Gases:           gasAmounts(factor, req.Amount),
+	}, nil
+}
+
+func (fuelCalculator) Suggestions(req CalculateRequest, carbonFootprint float64) []string {
+	return []string{
+		"Consider electric vehicles for zero direct emissions",
+		"Use biofuels to reduce carbon intensity",
+	}
+}
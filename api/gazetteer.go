@@ -0,0 +1,152 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//go:embed data/cities.csv
+var citiesCSV string
+
+//go:embed data/ports.csv
+var portsCSV string
+
+// Place is a resolved location from the embedded gazetteer.
+type Place struct {
+	Name     string      `json:"name"`
+	Kind     string      `json:"kind"` // city, port, airport or coordinates
+	Code     string      `json:"code,omitempty"`
+	Country  string      `json:"country,omitempty"`
+	Location Coordinates `json:"location"`
+}
+
+// Precise reports whether the place pins down an actual terminal rather than
+// a city centroid.
+func (p Place) Precise() bool {
+	return p.Kind != "city"
+}
+
+// gazetteer indexes places by code (UN/LOCODE, IATA) and by normalised name.
+type gazetteer struct {
+	byCode map[string]Place
+	byName map[string]Place
+}
+
+var places = mustLoadGazetteer()
+
+// resolvePlace looks a location up as "lat,lon", UN/LOCODE, IATA code or city
+// name, in that order. Unknown locations are reported rather than guessed.
+func resolvePlace(query string) (Place, bool) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return Place{}, false
+	}
+	if place, ok := parseCoordinatePlace(query); ok {
+		return place, true
+	}
+	code := strings.ToUpper(strings.ReplaceAll(query, " ", ""))
+	if place, ok := places.byCode[code]; ok {
+		return place, true
+	}
+	place, ok := places.byName[normalizePlaceName(query)]
+	return place, ok
+}
+
+func parseCoordinatePlace(query string) (Place, bool) {
+	parts := strings.Split(query, ",")
+	if len(parts) != 2 {
+		return Place{}, false
+	}
+	lat, latErr := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lon, lonErr := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if latErr != nil || lonErr != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return Place{}, false
+	}
+	return Place{
+		Name:     query,
+		Kind:     "coordinates",
+		Location: Coordinates{Latitude: lat, Longitude: lon},
+	}, true
+}
+
+// normalizePlaceName folds case and drops punctuation and spaces so that
+// "Los Angeles", "los-angeles" and "LosAngeles" all match.
+func normalizePlaceName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func mustLoadGazetteer() gazetteer {
+	g := gazetteer{
+		byCode: make(map[string]Place),
+		byName: make(map[string]Place),
+	}
+
+	for _, record := range mustReadEmbeddedCSV("cities", citiesCSV) {
+		city := Place{
+			Name:     record[0],
+			Kind:     "city",
+			Country:  record[2],
+			Location: mustParseCoordinates("city "+record[0], record[3], record[4]),
+		}
+		g.byName[normalizePlaceName(city.Name)] = city
+		for _, alias := range strings.Split(record[1], "|") {
+			if alias != "" {
+				g.byName[normalizePlaceName(alias)] = city
+			}
+		}
+	}
+
+	for _, record := range mustReadEmbeddedCSV("ports", portsCSV) {
+		g.byCode[record[0]] = Place{
+			Name:     record[1],
+			Kind:     "port",
+			Code:     record[0],
+			Country:  record[2],
+			Location: mustParseCoordinates("port "+record[0], record[3], record[4]),
+		}
+	}
+
+	for code, airport := range airports {
+		g.byCode[code] = Place{
+			Name:     airport.Name,
+			Kind:     "airport",
+			Code:     code,
+			Country:  airport.Country,
+			Location: airport.Location,
+		}
+	}
+
+	return g
+}
+
+// mustReadEmbeddedCSV parses a compiled-in data file, dropping the header row.
+func mustReadEmbeddedCSV(name, data string) [][]string {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded %s data: %v", name, err))
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return records[1:]
+}
+
+func mustParseCoordinates(label, lat, lon string) Coordinates {
+	latitude, latErr := strconv.ParseFloat(lat, 64)
+	longitude, lonErr := strconv.ParseFloat(lon, 64)
+	if latErr != nil || lonErr != nil {
+		panic(fmt.Sprintf("invalid coordinates for %s", label))
+	}
+	return Coordinates{Latitude: latitude, Longitude: longitude}
+}
@@ -0,0 +1,136 @@
+package main
+
+import "math"
+
+// earthRadiusKm is the IUGG mean Earth radius.
+const earthRadiusKm = 6371.0088
+
+// Coordinates is a WGS84 latitude/longitude pair in decimal degrees.
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// greatCircleKm returns the haversine distance between two points.
+func greatCircleKm(a, b Coordinates) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// routingUplift converts great-circle distance into an estimate of the
+// distance actually travelled by a mode.
+type routingUplift struct {
+	Factor float64 `json:"factor"`
+	AddKm  float64 `json:"add_km,omitempty"`
+	Source string  `json:"source"`
+}
+
+func (u routingUplift) apply(greatCircle float64) float64 {
+	return greatCircle*u.Factor + u.AddKm
+}
+
+// routingUplifts are the per-mode detour factors applied to great-circle
+// distance. Unknown modes get no uplift.
+var routingUplifts = map[string]routingUplift{
+	"air":           {Factor: 1.0, AddKm: 95, Source: "EN 16258 / GLEC"},
+	"passenger_air": {Factor: 1.08, Source: "DEFRA"},
+	"road":          {Factor: 1.2, Source: "GLEC"},
+	"rail":          {Factor: 1.25, Source: "GLEC"},
+	"sea":           {Factor: 1.15, Source: "GLEC"},
+}
+
+func upliftFor(mode string) routingUplift {
+	if uplift, exists := routingUplifts[mode]; exists {
+		return uplift
+	}
+	return routingUplift{Factor: 1.0, Source: "none"}
+}
+
+// Route is the estimated distance between two gazetteer places for a mode.
+type Route struct {
+	From          Place         `json:"from"`
+	To            Place         `json:"to"`
+	GreatCircleKm float64       `json:"great_circle_km"`
+	DistanceKm    float64       `json:"distance_km"`
+	Uplift        routingUplift `json:"uplift"`
+	// Confidence is "high" when both ends are terminals or coordinates and
+	// "medium" when either end is only known as a city centroid.
+	Confidence string `json:"confidence"`
+	// Uncertainty is the range of the actual distance around the estimate.
+	Uncertainty *Uncertainty `json:"uncertainty"`
+}
+
+// routeUncertainties bound the distance actually travelled relative to the
+// estimate, by confidence. Detours are more likely than shortcuts, so the
+// ranges lean high.
+var routeUncertainties = map[string]Uncertainty{
+	"high":   {Distribution: DistributionTriangular, Low: 0.9, High: 1.2},
+	"medium": {Distribution: DistributionTriangular, Low: 0.8, High: 1.4},
+}
+
+// uncertainty returns the range of an estimated route, nil for a provided
+// distance.
+func (r *Route) uncertainty() *Uncertainty {
+	if r == nil {
+		return nil
+	}
+	return r.Uncertainty
+}
+
+// planRoute resolves both ends of a journey and estimates its distance. It
+// returns a ValidationError naming the field that could not be resolved.
+func planRoute(from, to, mode string) (Route, error) {
+	origin, ok := resolvePlace(from)
+	if !ok {
+		return Route{}, invalidField("from", "unknown location %q", from)
+	}
+	destination, ok := resolvePlace(to)
+	if !ok {
+		return Route{}, invalidField("to", "unknown location %q", to)
+	}
+
+	greatCircle := greatCircleKm(origin.Location, destination.Location)
+	uplift := upliftFor(mode)
+
+	confidence := "high"
+	if !origin.Precise() || !destination.Precise() {
+		confidence = "medium"
+	}
+
+	uncertainty := routeUncertainties[confidence]
+	return Route{
+		From:          origin,
+		To:            destination,
+		GreatCircleKm: greatCircle,
+		DistanceKm:    uplift.apply(greatCircle),
+		Uplift:        uplift,
+		Confidence:    confidence,
+		Uncertainty:   &uncertainty,
+	}, nil
+}
+
+// legDistance returns the provided distance, or estimates it from the two
+// locations when none was given. The route is nil for provided distances.
+func legDistance(distance float64, from, to, mode string) (float64, *Route, error) {
+	if distance < 0 {
+		return 0, nil, invalidField("distance", "must not be negative")
+	}
+	if distance > 0 {
+		return distance, nil, nil
+	}
+	if from == "" || to == "" {
+		return 0, nil, invalidField("distance", "either distance or both from and to are required")
+	}
+	route, err := planRoute(from, to, mode)
+	if err != nil {
+		return 0, nil, err
+	}
+	return route.DistanceKm, &route, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestResolvePlace(t *testing.T) {
+	tests := []struct {
+		query   string
+		kind    string
+		name    string
+		country string
+	}{
+		{"NLRTM", "port", "Rotterdam", "NL"},
+		{" nl rtm ", "port", "Rotterdam", "NL"},
+		{"JFK", "airport", "John F. Kennedy International", "US"},
+		{"lhr", "airport", "Heathrow", "GB"},
+		{"Los Angeles", "city", "Los Angeles", "US"},
+		{"los-angeles", "city", "Los Angeles", "US"},
+		{"LA", "city", "Los Angeles", "US"},
+		{"New York City", "city", "New York", "US"},
+		{"51.5, -0.12", "coordinates", "51.5, -0.12", ""},
+		{"91,0", "", "", ""},
+		{"Atlantis", "", "", ""},
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		place, ok := resolvePlace(tt.query)
+		if ok != (tt.kind != "") {
+			t.Errorf("resolvePlace(%q) found = %v", tt.query, ok)
+			continue
+		}
+		if place.Kind != tt.kind || place.Name != tt.name || place.Country != tt.country {
+			t.Errorf("resolvePlace(%q) = %s %q in %q, want %s %q in %q",
+				tt.query, place.Kind, place.Name, place.Country, tt.kind, tt.name, tt.country)
+		}
+	}
+}
+
+func TestPlanRoute(t *testing.T) {
+	// One degree of longitude on the equator
+	const degree = 111.1951
+
+	tests := []struct {
+		name        string
+		from, to    string
+		mode        string
+		greatCircle float64
+		distance    float64
+		confidence  string
+		field       string
+	}{
+		{"road between coordinates", "0,0", "0,1", "road", degree, degree * 1.2, "high", ""},
+		{"air adds a fixed detour", "0,0", "0,1", "air", degree, degree + 95, "high", ""},
+		{"unknown mode has no uplift", "0,0", "0,1", "hovercraft", degree, degree, "high", ""},
+		{"port to port", "CNSZX", "NLRTM", "sea", 9313.4, 9313.4 * 1.15, "high", ""},
+		{"city centroid", "Shenzhen", "NLRTM", "sea", 9318.3, 9318.3 * 1.15, "medium", ""},
+		{"unknown origin", "Atlantis", "NLRTM", "sea", 0, 0, "", "from"},
+		{"unknown destination", "NLRTM", "Atlantis", "sea", 0, 0, "", "to"},
+	}
+	for _, tt := range tests {
+		route, err := planRoute(tt.from, tt.to, tt.mode)
+		if tt.field != "" {
+			var validation *ValidationError
+			if !errors.As(err, &validation) || validation.Field != tt.field {
+				t.Errorf("%s: error = %v, want one about %s", tt.name, err, tt.field)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if math.Abs(route.GreatCircleKm-tt.greatCircle) > 0.1 || math.Abs(route.DistanceKm-tt.distance) > 0.2 {
+			t.Errorf("%s: %.1f km great circle, %.1f km travelled; want %.1f and %.1f",
+				tt.name, route.GreatCircleKm, route.DistanceKm, tt.greatCircle, tt.distance)
+		}
+		if route.Confidence != tt.confidence {
+			t.Errorf("%s: confidence = %s, want %s", tt.name, route.Confidence, tt.confidence)
+		}
+	}
+}
+
+func TestLegDistance(t *testing.T) {
+	tests := []struct {
+		name      string
+		distance  float64
+		from, to  string
+		want      float64
+		estimated bool
+		field     string
+	}{
+		{"provided", 250, "CNSZX", "NLRTM", 250, false, ""},
+		{"estimated", 0, "0,0", "0,1", 111.1951 * 1.2, true, ""},
+		{"negative", -1, "", "", 0, false, "distance"},
+		{"missing destination", 0, "CNSZX", "", 0, false, "distance"},
+		{"unknown place", 0, "CNSZX", "Atlantis", 0, false, "to"},
+	}
+	for _, tt := range tests {
+		distance, route, err := legDistance(tt.distance, tt.from, tt.to, "road")
+		var validation *ValidationError
+		switch {
+		case tt.field != "":
+			if !errors.As(err, &validation) || validation.Field != tt.field {
+				t.Errorf("%s: error = %v, want one about %s", tt.name, err, tt.field)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case math.Abs(distance-tt.want) > 0.1 || (route != nil) != tt.estimated:
+			t.Errorf("%s: %.1f km, estimated %v; want %.1f km, %v", tt.name, distance, route != nil, tt.want, tt.estimated)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// GHG Protocol scopes.
+const (
+	Scope1 = 1 // direct emissions from owned or controlled sources
+	Scope2 = 2 // purchased electricity, steam, heating and cooling
+	Scope3 = 3 // all other indirect emissions in the value chain
+)
+
+// scope3Categories are the fifteen Scope 3 categories of the GHG Protocol
+// Corporate Value Chain Standard.
+var scope3Categories = map[int]string{
+	1:  "Purchased goods and services",
+	2:  "Capital goods",
+	3:  "Fuel- and energy-related activities",
+	4:  "Upstream transportation and distribution",
+	5:  "Waste generated in operations",
+	6:  "Business travel",
+	7:  "Employee commuting",
+	8:  "Upstream leased assets",
+	9:  "Downstream transportation and distribution",
+	10: "Processing of sold products",
+	11: "Use of sold products",
+	12: "End-of-life treatment of sold products",
+	13: "Downstream leased assets",
+	14: "Franchises",
+	15: "Investments",
+}
+
+// Where a calculation's scope came from.
+const (
+	scopeFromRequest = "request"
+	scopeFromFactor  = "factor"
+	scopeFromDefault = "default"
+)
+
+// validateGHGScope checks a scope and Scope 3 category pair. Either may be
+// zero for "not set"; a category implies Scope 3.
+func validateGHGScope(scope, category int) error {
+	if scope != 0 && (scope < Scope1 || scope > Scope3) {
+		return invalidField("scope", "must be 1, 2 or 3")
+	}
+	if category == 0 {
+		return nil
+	}
+	if _, known := scope3Categories[category]; !known {
+		return invalidField("scope3_category", "must be between 1 and 15")
+	}
+	if scope != 0 && scope != Scope3 {
+		return invalidField("scope3_category", "only applies to scope 3")
+	}
+	return nil
+}
+
+// defaultGHGScope classifies the built-in activities from the reporting
+// company's point of view: it burns the fuel, buys the electricity, and
+// pays for freight to reach it and for its staff to fly. Freight shipped to
+// customers (category 9) has to be set by the caller.
+func defaultGHGScope(activity string) (scope, category int) {
+	switch activity {
+	case "fuel":
+		return Scope1, 0
+	case "electricity":
+		return Scope2, 0
+	case "shipping", "shipment":
+		return Scope3, 4
+	case "flight":
+		return Scope3, 6
+	}
+	return 0, 0
+}
+
+// classifyCalculation picks the scope of a calculation: the caller's
+// override, else the scope set on the primary factor, else the default for
+// the activity.
+func classifyCalculation(req CalculateRequest, factors []FactorReference) (scope, category int, source string) {
+	if req.Scope != 0 || req.Scope3Category != 0 {
+		scope = req.Scope
+		if scope == 0 {
+			scope = Scope3
+		}
+		return scope, req.Scope3Category, scopeFromRequest
+	}
+	if len(factors) > 0 && factors[0].Scope != 0 {
+		return factors[0].Scope, factors[0].Scope3Category, scopeFromFactor
+	}
+	if scope, category = defaultGHGScope(req.Activity); scope != 0 {
+		return scope, category, scopeFromDefault
+	}
+	return 0, 0, ""
+}
+
+// scopeKey names a scope in analytics output.
+func scopeKey(scope int) string {
+	if scope == 0 {
+		return "unclassified"
+	}
+	return "scope_" + strconv.Itoa(scope)
+}
+
+// Scope3CategoryTotal is the footprint reported under one Scope 3 category.
+type Scope3CategoryTotal struct {
+	Category        int     `json:"category"`
+	Name            string  `json:"name"`
+	CarbonFootprint float64 `json:"carbon_footprint"`
+}
+
+func scope3CategoryTotals(totals map[int]float64) []Scope3CategoryTotal {
+	list := make([]Scope3CategoryTotal, 0, len(totals))
+	for category, total := range totals {
+		list = append(list, Scope3CategoryTotal{
+			Category:        category,
+			Name:            firstNonEmpty(scope3Categories[category], "Uncategorized"),
+			CarbonFootprint: roundTo(total, 2),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Category < list[j].Category })
+	return list
+}
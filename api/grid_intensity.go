@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxIntensitySpan bounds one lookup of hourly intensity: a leap year.
+	maxIntensitySpan = 366 * 24 * time.Hour
+	// maxLoadIntervals allows a year of 15-minute readings.
+	maxLoadIntervals = 366 * 24 * 4
+)
+
+// IntensityProvider fetches hourly grid intensity from outside the API,
+// such as a grid operator or a commercial service. Fetched hours are stored,
+// so a provider is only asked when the store lacks some of a range.
+type IntensityProvider interface {
+	Name() string
+	Fetch(region string, from, to time.Time) ([]IntensityPoint, error)
+}
+
+// intensityProviderFromEnv reads INTENSITY_PROVIDER. "file" reads series
+// from INTENSITY_FILE_DIR; empty means stored series only.
+func intensityProviderFromEnv() IntensityProvider {
+	switch provider := os.Getenv("INTENSITY_PROVIDER"); provider {
+	case "":
+		return nil
+	case "file":
+		dir := os.Getenv("INTENSITY_FILE_DIR")
+		if dir == "" {
+			log.Fatal("INTENSITY_PROVIDER=file requires INTENSITY_FILE_DIR")
+		}
+		return fileIntensityProvider{dir: dir}
+	default:
+		log.Fatalf("Unknown INTENSITY_PROVIDER %q", provider)
+		return nil
+	}
+}
+
+// fileIntensityProvider stands in for a real provider by reading one CSV
+// per region, named after it (US-CAMX.csv), in the import format.
+type fileIntensityProvider struct {
+	dir string
+}
+
+func (p fileIntensityProvider) Name() string { return "file" }
+
+func (p fileIntensityProvider) Fetch(region string, from, to time.Time) ([]IntensityPoint, error) {
+	file, err := os.Open(filepath.Join(p.dir, filepath.Base(region)+".csv"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	points, rowErrors, err := readIntensityCSV(file, region, "file:"+region)
+	if err != nil {
+		return nil, err
+	}
+	if len(rowErrors) > 0 {
+		return nil, fmt.Errorf("%s.csv row %d: %s", region, rowErrors[0].Row, rowErrors[0].Message)
+	}
+
+	var inRange []IntensityPoint
+	for _, point := range points {
+		if point.Region == region && !point.Hour.Before(from) && point.Hour.Before(to) {
+			inRange = append(inRange, point)
+		}
+	}
+	return inRange, nil
+}
+
+// IntensitySeries is the hourly intensity found for a request, from the
+// first region of its fallback chain that has any.
+type IntensitySeries struct {
+	Region string
+	Points []IntensityPoint
+}
+
+// HourlyIntensity returns the hourly intensity of region in [from, to),
+// falling back through its country and continent like factor lookups. Hours
+// the store lacks are fetched from the provider, if one is configured, and
+// stored. The series is empty when no level has data.
+func (cs *CarbonService) HourlyIntensity(region string, from, to time.Time) (IntensitySeries, error) {
+	from, to = from.UTC().Truncate(time.Hour), to.UTC()
+	hours := int(to.Sub(from).Hours() + 0.999)
+
+	for _, candidate := range regionChain(region) {
+		points, err := cs.store.GridIntensity(candidate, from, to)
+		if err != nil {
+			return IntensitySeries{}, err
+		}
+		if len(points) < hours && cs.intensity != nil {
+			points = cs.fetchIntensity(candidate, from, to, points)
+		}
+		if len(points) > 0 {
+			return IntensitySeries{Region: candidate, Points: points}, nil
+		}
+	}
+	return IntensitySeries{}, nil
+}
+
+// fetchIntensity fills the hours missing from stored with the provider's.
+// Provider failures are logged and the stored hours used as they are.
+func (cs *CarbonService) fetchIntensity(region string, from, to time.Time, stored []IntensityPoint) []IntensityPoint {
+	fetched, err := cs.intensity.Fetch(region, from, to)
+	if err != nil {
+		log.Printf("Intensity provider %s failed for %s: %v", cs.intensity.Name(), region, err)
+		return stored
+	}
+	if len(fetched) == 0 {
+		return stored
+	}
+
+	have := make(map[time.Time]bool, len(stored))
+	for _, point := range stored {
+		have[point.Hour] = true
+	}
+	var missing []IntensityPoint
+	for _, point := range fetched {
+		if !have[point.Hour] {
+			missing = append(missing, point)
+		}
+	}
+	if len(missing) == 0 {
+		return stored
+	}
+	if err := cs.store.SaveGridIntensity(missing); err != nil {
+		log.Printf("Failed to store fetched intensity for %s: %v", region, err)
+	}
+
+	points := append(append([]IntensityPoint(nil), stored...), missing...)
+	sortIntensity(points)
+	return points
+}
+
+// ImportGridIntensity handles POST /admin/grid-intensity. The body, or a
+// multipart "file", is a CSV with an hour column and an intensity column
+// in kg_co2e_per_kwh or g_co2e_per_kwh; region comes from a region column
+// or the region query parameter. Nothing is written if any row is invalid.
+func (cs *CarbonService) ImportGridIntensity(c *fiber.Ctx) error {
+	var body io.Reader = bytes.NewReader(c.Body())
+	if header, err := c.FormFile("file"); err == nil {
+		file, err := header.Open()
+		if err != nil {
+			return validationResponse(c, errors.New("Unable to read uploaded file"))
+		}
+		defer file.Close()
+		body = file
+	}
+
+	points, rowErrors, err := readIntensityCSV(body, normalizeRegion(c.Query("region")), c.Query("source"))
+	if err != nil {
+		return validationResponse(c, err)
+	}
+	if len(rowErrors) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   true,
+			"message": "Import rejected, no intensities were written",
+			"errors":  rowErrors,
+		})
+	}
+	if len(points) == 0 {
+		return validationResponse(c, errors.New("No intensities to import"))
+	}
+
+	if err := cs.store.SaveGridIntensity(points); err != nil {
+		log.Printf("Failed to import grid intensity: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to import grid intensity")
+	}
+
+	regions := map[string]int{}
+	for _, point := range points {
+		regions[point.Region]++
+	}
+	return c.Status(201).JSON(fiber.Map{
+		"imported": len(points),
+		"regions":  regions,
+	})
+}
+
+// GetGridIntensity handles GET /grid-intensity?region=&from=&to=. The range
+// defaults to the current UTC day.
+func (cs *CarbonService) GetGridIntensity(c *fiber.Ctx) error {
+	region := normalizeRegion(c.Query("region"))
+	if region == "" {
+		return validationResponse(c, invalidField("region", "is required"))
+	}
+	from, err := parseTimeQuery(c.Query("from"), false)
+	if err != nil {
+		return validationResponse(c, invalidField("from", "%v", err))
+	}
+	to, err := parseTimeQuery(c.Query("to"), true)
+	if err != nil {
+		return validationResponse(c, invalidField("to", "%v", err))
+	}
+	if from.IsZero() {
+		from = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if to.IsZero() {
+		to = from.Add(24 * time.Hour)
+	}
+	if !to.After(from) || to.Sub(from) > maxIntensitySpan {
+		return validationResponse(c, invalidField("to", "must be after from and at most 366 days later"))
+	}
+
+	series, err := cs.HourlyIntensity(region, from, to)
+	if err != nil {
+		log.Printf("Failed to load grid intensity: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to load grid intensity")
+	}
+	points, level := series.Points, ""
+	if points == nil {
+		points = []IntensityPoint{}
+	} else {
+		level = regionLevel(series.Region)
+	}
+
+	return c.JSON(fiber.Map{
+		"requested_region": region,
+		"region":           series.Region,
+		"region_level":     level,
+		"unit":             "kg_co2e_per_kwh",
+		"from":             from.UTC(),
+		"to":               to.UTC(),
+		"count":            len(points),
+		"intensity":        points,
+	})
+}
+
+// intensityTimeLayouts are the hour formats accepted in CSV files; times
+// without an offset are UTC.
+var intensityTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// readIntensityCSV parses hourly intensity rows. Row problems are returned
+// as rowErrors; err is for files that cannot be read at all.
+func readIntensityCSV(r io.Reader, defaultRegion, defaultSource string) (points []IntensityPoint, rowErrors []FactorImportError, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New("CSV file is empty or unreadable")
+	}
+
+	column := func(names ...string) int {
+		for i, cell := range header {
+			cell = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(cell, "\ufeff")))
+			for _, name := range names {
+				if cell == name {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	regionCol := column("region")
+	hourCol := column("hour", "datetime", "timestamp")
+	sourceCol := column("source")
+	kgCol := column("kg_co2e_per_kwh", "intensity")
+	gCol := column("g_co2e_per_kwh")
+
+	switch {
+	case hourCol < 0:
+		return nil, nil, errors.New("CSV needs an hour column")
+	case kgCol < 0 && gCol < 0:
+		return nil, nil, errors.New("CSV needs a kg_co2e_per_kwh or g_co2e_per_kwh column")
+	case regionCol < 0 && defaultRegion == "":
+		return nil, nil, errors.New("CSV needs a region column or the region parameter")
+	}
+
+	cell := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("CSV row %d: %v", row, err)
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		point := IntensityPoint{
+			Region: firstNonEmpty(normalizeRegion(cell(record, regionCol)), defaultRegion),
+			Source: firstNonEmpty(cell(record, sourceCol), defaultSource),
+		}
+		hour, ok := parseIntensityHour(cell(record, hourCol))
+		if !ok {
+			rowErrors = append(rowErrors, FactorImportError{Row: row, Message: fmt.Sprintf("invalid hour %q", cell(record, hourCol))})
+			continue
+		}
+		point.Hour = hour
+
+		if value, ok := parseDatasetNumber(cell(record, kgCol)); ok {
+			point.Intensity = value
+		} else if value, ok := parseDatasetNumber(cell(record, gCol)); ok {
+			point.Intensity = value / 1000
+		} else {
+			rowErrors = append(rowErrors, FactorImportError{Row: row, Message: "intensity is missing or not a number"})
+			continue
+		}
+		if point.Intensity < 0 {
+			rowErrors = append(rowErrors, FactorImportError{Row: row, Message: "intensity must not be negative"})
+			continue
+		}
+		if len(point.Region) > 50 || len(point.Source) > 100 {
+			rowErrors = append(rowErrors, FactorImportError{Row: row, Message: "region or source is too long"})
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, rowErrors, nil
+}
+
+// parseIntensityHour reads an hour and truncates it to the start of the hour
+// in UTC.
+func parseIntensityHour(value string) (time.Time, bool) {
+	for _, layout := range intensityTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Truncate(time.Hour), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func sortIntensity(points []IntensityPoint) {
+	sort.Slice(points, func(i, j int) bool { return points[i].Hour.Before(points[j].Hour) })
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed data/grid_intensity.csv
+var gridIntensityCSV string
+
+// gridFactorVersion labels the factors of the embedded grid dataset.
+const gridFactorVersion = "builtin-grid-2023"
+
+// Region levels, from most to least specific.
+const (
+	RegionSubregion = "subregion"
+	RegionCountry   = "country"
+	RegionContinent = "continent"
+	RegionWorld     = "world"
+)
+
+// GridRegion is an entry of the embedded grid intensity dataset: annual
+// average location-based factors for continents, countries and subnational
+// grids (eGRID subregions, Canadian provinces, Australian states). They are
+// rounded published figures meant as a fallback; import the official
+// dataset for reporting.
+type GridRegion struct {
+	Code   string
+	Parent string
+	Level  string
+	Name   string
+	Factor float64 // kg CO2e per kWh
+	Source string
+}
+
+var gridRegions = mustLoadGridRegions(gridIntensityCSV)
+
+func mustLoadGridRegions(data string) map[string]GridRegion {
+	records := mustReadEmbeddedCSV("grid intensity", data)
+	result := make(map[string]GridRegion, len(records))
+	for _, record := range records {
+		factor, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid embedded grid intensity for %s: %v", record[0], err))
+		}
+		result[record[0]] = GridRegion{
+			Code:   record[0],
+			Parent: record[1],
+			Level:  record[2],
+			Name:   record[3],
+			Factor: factor,
+			Source: record[5],
+		}
+	}
+	return result
+}
+
+// requestRegion combines a request's region and country into one region
+// code: "CAMX" with country "US" becomes "US-CAMX".
+func requestRegion(req CalculateRequest) string {
+	return combineRegion(req.Region, req.Country)
+}
+
+func combineRegion(region, country string) string {
+	region, country = normalizeRegion(region), normalizeRegion(country)
+	switch {
+	case region == "":
+		return country
+	case country == "" || region == country || strings.HasPrefix(region, country+"-"):
+		return region
+	}
+	return country + "-" + region
+}
+
+func normalizeRegion(region string) string {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if region == "UK" {
+		return "GB"
+	}
+	return region
+}
+
+// regionChain lists the regions to try for a factor, most specific first and
+// always ending in WORLD. Codes missing from the dataset still fall back by
+// their country prefix, so "US-XYZ" tries US next.
+func regionChain(region string) []string {
+	var chain []string
+	for region != "" && region != worldRegion && len(chain) < 5 {
+		chain = append(chain, region)
+		if known, ok := gridRegions[region]; ok {
+			region = known.Parent
+		} else if i := strings.IndexByte(region, '-'); i > 0 {
+			region = region[:i]
+		} else {
+			region = ""
+		}
+	}
+	return append(chain, worldRegion)
+}
+
+// regionLevel names how specific a region is.
+func regionLevel(region string) string {
+	if known, ok := gridRegions[region]; ok {
+		return known.Level
+	}
+	switch {
+	case region == worldRegion || region == "":
+		return RegionWorld
+	case strings.Contains(region, "-"):
+		return RegionSubregion
+	}
+	return RegionCountry
+}
+
+// gridIntensityFactors returns the embedded dataset as grid electricity
+// factors, ordered by region.
+func gridIntensityFactors() []EmissionFactor {
+	factors := make([]EmissionFactor, 0, len(gridRegions))
+	for _, region := range gridRegions {
+		factors = append(factors, region.emissionFactor())
+	}
+	sort.Slice(factors, func(i, j int) bool { return factors[i].Region < factors[j].Region })
+	return factors
+}
+
+// builtinRegionalFactor returns the embedded grid factor of one region.
+func builtinRegionalFactor(q FactorQuery) (EmissionFactor, bool) {
+	if q.Activity != "electricity" || q.Mode != "grid" {
+		return EmissionFactor{}, false
+	}
+	region, ok := gridRegions[q.Region]
+	if !ok {
+		return EmissionFactor{}, false
+	}
+	return region.emissionFactor(), true
+}
+
+func (r GridRegion) emissionFactor() EmissionFactor {
+	return EmissionFactor{
+		Activity:      "electricity",
+		TransportMode: "grid",
+		Region:        r.Code,
+		Factor:        r.Factor,
+		Unit:          "kg_co2e_per_kwh",
+		Source:        r.Source,
+		Version:       gridFactorVersion,
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegionChain(t *testing.T) {
+	tests := []struct {
+		region string
+		want   []string
+	}{
+		{"US-CAMX", []string{"US-CAMX", "US", "NORTH_AMERICA", "WORLD"}},
+		{"US-XYZ", []string{"US-XYZ", "US", "NORTH_AMERICA", "WORLD"}},
+		{"GB", []string{"GB", "EUROPE", "WORLD"}},
+		{"EUROPE", []string{"EUROPE", "WORLD"}},
+		{"ZZ", []string{"ZZ", "WORLD"}},
+		{"ZZ-ABC", []string{"ZZ-ABC", "ZZ", "WORLD"}},
+		{"WORLD", []string{"WORLD"}},
+		{"", []string{"WORLD"}},
+	}
+	for _, tt := range tests {
+		if got := regionChain(tt.region); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("regionChain(%q) = %v, want %v", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestCombineRegion(t *testing.T) {
+	tests := []struct {
+		region, country string
+		want            string
+	}{
+		{"CAMX", "US", "US-CAMX"},
+		{"us-camx", "US", "US-CAMX"},
+		{"US-CAMX", "", "US-CAMX"},
+		{"", "uk", "GB"},
+		{"SCT", "UK", "GB-SCT"},
+		{"GB", "UK", "GB"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := combineRegion(tt.region, tt.country); got != tt.want {
+			t.Errorf("combineRegion(%q, %q) = %q, want %q", tt.region, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestGridFactorFallback(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	tests := []struct {
+		region string
+		factor float64
+		found  string
+		level  string
+	}{
+		{"US-CAMX", 0.226, "US-CAMX", RegionSubregion},
+		{"US-XYZ", 0.369, "US", RegionCountry},
+		{"FR", 0.085, "FR", RegionCountry},
+		{"ZZ", 0.525, "WORLD", RegionWorld},
+		{"", 0.525, "WORLD", RegionWorld},
+	}
+	for _, tt := range tests {
+		factor, err := cs.getEmissionFactor(FactorQuery{Activity: "electricity", Mode: "grid", Region: tt.region})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.region, err)
+		}
+		if factor.Factor != tt.factor || factor.Region != tt.found || regionLevel(factor.Region) != tt.level {
+			t.Errorf("%s: %v from %s (%s), want %v from %s (%s)",
+				tt.region, factor.Factor, factor.Region, regionLevel(factor.Region), tt.factor, tt.found, tt.level)
+		}
+	}
+}
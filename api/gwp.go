@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// GWPSet holds the 100-year global warming potentials of one IPCC
+// assessment report. CO2 is 1 by definition.
+type GWPSet struct {
+	Name string  `json:"name"`
+	CH4  float64 `json:"ch4"`
+	N2O  float64 `json:"n2o"`
+}
+
+// gwpSets are the assessment reports a calculation can be expressed in. AR6
+// also lists 29.8 for fossil methane, counting the CO2 it oxidises to; the
+// general methane value is used here.
+var gwpSets = map[string]GWPSet{
+	"AR4": {Name: "AR4", CH4: 25, N2O: 298},
+	"AR5": {Name: "AR5", CH4: datasetGWPCH4, N2O: datasetGWPN2O},
+	"AR6": {Name: "AR6", CH4: 27.9, N2O: 273},
+}
+
+// defaultGWPSet matches the GWPs the stored CO2e factors were published with.
+const defaultGWPSet = "AR5"
+
+// lookupGWPSet resolves a request's gwp field, case-insensitively.
+func lookupGWPSet(name string) (GWPSet, error) {
+	if name == "" {
+		name = defaultGWPSet
+	}
+	set, ok := gwpSets[strings.ToUpper(name)]
+	if !ok {
+		names := make([]string, 0, len(gwpSets))
+		for name := range gwpSets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return GWPSet{}, invalidField("gwp", "must be one of %s", strings.Join(names, ", "))
+	}
+	return set, nil
+}
+
+// co2e combines a gas split into a CO2e factor.
+func (g GasFactors) co2e(gwp GWPSet) float64 {
+	return g.CO2 + g.CH4*gwp.CH4 + g.N2O*gwp.N2O
+}
+
+// GasAmounts is the mass of each gas a calculation emitted, in kg.
+type GasAmounts struct {
+	CO2 float64
+	CH4 float64
+	N2O float64
+}
+
+// gasAmounts returns the gases emitted by quantity units of a factor's
+// activity, or nil when the factor has no gas split.
+func gasAmounts(factor EmissionFactor, quantity float64) *GasAmounts {
+	if factor.Gases == nil {
+		return nil
+	}
+	return &GasAmounts{
+		CO2: factor.Gases.CO2 * quantity,
+		CH4: factor.Gases.CH4 * quantity,
+		N2O: factor.Gases.N2O * quantity,
+	}
+}
+
+// plus adds two partial results. The sum is unknown, and nil, if either is.
+func (a *GasAmounts) plus(b *GasAmounts) *GasAmounts {
+	if a == nil || b == nil {
+		return nil
+	}
+	return &GasAmounts{CO2: a.CO2 + b.CO2, CH4: a.CH4 + b.CH4, N2O: a.N2O + b.N2O}
+}
+
+// GasBreakdown reports each gas as mass and as CO2e under the selected GWP
+// set.
+type GasBreakdown struct {
+	CO2Kg     float64 `json:"co2_kg"`
+	CH4Kg     float64 `json:"ch4_kg"`
+	N2OKg     float64 `json:"n2o_kg"`
+	CH4KgCO2e float64 `json:"ch4_kg_co2e"`
+	N2OKgCO2e float64 `json:"n2o_kg_co2e"`
+}
+
+func (a GasAmounts) breakdown(gwp GWPSet) GasBreakdown {
+	return GasBreakdown{
+		CO2Kg:     roundTo(a.CO2, 6),
+		CH4Kg:     roundTo(a.CH4, 6),
+		N2OKg:     roundTo(a.N2O, 6),
+		CH4KgCO2e: roundTo(a.CH4*gwp.CH4, 6),
+		N2OKgCO2e: roundTo(a.N2O*gwp.N2O, 6),
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGWPAppliedOnlyToSplitFactors(t *testing.T) {
+	parsed, err := defraDataset{}.Parse(strings.NewReader(defraSample), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	if _, err := cs.importEmissionFactors(parsed.Factors, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The imported diesel factor splits into 2.4 CO2, 0.028 CO2e of CH4 and
+	// 0.072 CO2e of N2O under AR5; the built-in one has no split.
+	tests := []struct {
+		name    string
+		region  string
+		gwp     string
+		want    float64
+		applied bool
+	}{
+		{"split, AR5", "GB", "AR5", 250, true},
+		{"split, AR4", "GB", "AR4", 250.597, true},
+		{"split, AR6", "GB", "AR6", 250.207, true},
+		{"no split, AR5", "FR", "AR5", 268, false},
+		{"no split, AR6", "FR", "AR6", 268, false},
+	}
+	for _, tt := range tests {
+		req := CalculateRequest{Activity: "fuel", Amount: 100, Transport: "diesel", Region: tt.region, GWP: tt.gwp}
+		response, err := cs.calculateCarbonFootprint(req, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if response.CarbonFootprint != tt.want {
+			t.Errorf("%s: footprint = %v, want %v", tt.name, response.CarbonFootprint, tt.want)
+		}
+		if applied := response.Breakdown["gwp_applied"]; applied != tt.applied {
+			t.Errorf("%s: gwp_applied = %v, want %v", tt.name, applied, tt.applied)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long a request may hold its key before a
+	// retry can take over, in case the instance handling it died.
+	idempotencyLockTimeout  = time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyTTLFromEnv reads IDEMPOTENCY_TTL, how long responses are kept
+// for replay, as a Go duration such as "24h".
+func idempotencyTTLFromEnv() time.Duration {
+	raw := os.Getenv("IDEMPOTENCY_TTL")
+	if raw == "" {
+		return defaultIdempotencyTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Fatalf("Invalid IDEMPOTENCY_TTL %q", raw)
+	}
+	return ttl
+}
+
+// idempotencyHash identifies a request by method, URL and body, so a key
+// reused for a different request is detected.
+func idempotencyHash(c *fiber.Ctx) []byte {
+	h := sha256.New()
+	h.Write([]byte(c.Method() + " " + c.OriginalURL() + "\n"))
+	h.Write(c.Body())
+	return h.Sum(nil)
+}
+
+// idempotent makes retries of a request sent with an Idempotency-Key header
+// return the first response instead of running again. Keys are scoped to
+// the caller as rate limits are: by API key, user or, for anonymous
+// callers, client IP. Server errors and rate limit rejections are not kept, so the
+// request can be retried with the same key.
+func (cs *CarbonService) idempotent(ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("Idempotency-Key")
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return fiber.NewError(fiber.StatusBadRequest,
+				"Idempotency-Key must be at most 255 characters")
+		}
+
+		now := time.Now().UTC()
+		record := IdempotencyRecord{
+			Owner:       clientKey(c, principalFrom(c)),
+			Key:         key,
+			RequestHash: idempotencyHash(c),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}
+		existing, reserved, err := cs.store.ReserveIdempotencyKey(record, now.Add(-idempotencyLockTimeout))
+		if err != nil {
+			log.Printf("Idempotency key lookup failed: %v", err)
+			return fiber.NewError(fiber.StatusServiceUnavailable, "Unable to check Idempotency-Key, retry later")
+		}
+
+		if !reserved {
+			switch {
+			case !bytes.Equal(existing.RequestHash, record.RequestHash):
+				return fiber.NewError(fiber.StatusUnprocessableEntity,
+					"Idempotency-Key was already used for a different request")
+			case existing.CompletedAt == nil:
+				c.Set(fiber.HeaderRetryAfter, "1")
+				return fiber.NewError(fiber.StatusConflict,
+					"A request with this Idempotency-Key is still in progress")
+			}
+			c.Set("Idempotent-Replayed", "true")
+			c.Set(fiber.HeaderContentType, existing.ContentType)
+			return c.Status(existing.StatusCode).Send(existing.Body)
+		}
+
+		// Render errors here so the response can be stored
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+
+		status := c.Response().StatusCode()
+		if status >= 500 || status == fiber.StatusTooManyRequests {
+			if err := cs.store.ReleaseIdempotencyKey(record.Owner, record.Key); err != nil {
+				log.Printf("Failed to release Idempotency-Key: %v", err)
+			}
+			return nil
+		}
+
+		completed := time.Now().UTC()
+		record.StatusCode = status
+		record.ContentType = string(c.Response().Header.ContentType())
+		record.Body = append([]byte(nil), c.Response().Body()...)
+		record.CompletedAt = &completed
+		if err := cs.store.CompleteIdempotencyKey(record); err != nil {
+			log.Printf("Failed to store Idempotency-Key response: %v", err)
+		}
+		return nil
+	}
+}
+
+// purgeIdempotencyKeys deletes expired keys every interval until the
+// process exits.
+func (cs *CarbonService) purgeIdempotencyKeys(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := cs.store.PurgeIdempotencyKeys(time.Now().UTC()); err != nil {
+			log.Printf("Failed to purge idempotency keys: %v", err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Anonymous callers are told apart by address, so one cannot replay
+// another's response by guessing its key.
+func TestIdempotencyKeysAreScopedToTheCaller(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	app.Use(cs.requireAuth(AuthConfig{AllowAnonymous: true, AdminToken: "admin"}))
+
+	calls := 0
+	app.Post("/calculate", cs.idempotent(defaultIdempotencyTTL), func(c *fiber.Ctx) error {
+		calls++
+		return c.SendString(strconv.Itoa(calls))
+	})
+
+	tests := []struct {
+		ip, token string
+		want      string
+		replayed  bool
+	}{
+		{ip: "192.0.2.1", want: "1"},
+		{ip: "192.0.2.1", want: "1", replayed: true},
+		{ip: "192.0.2.2", want: "2"},
+		{ip: "192.0.2.1", token: "admin", want: "3"},
+		{ip: "192.0.2.2", token: "admin", want: "3", replayed: true},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("POST", "/calculate", nil)
+		req.Header.Set("Idempotency-Key", "same-key")
+		req.Header.Set(fiber.HeaderXForwardedFor, tt.ip)
+		if tt.token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, 8)
+		n, _ := resp.Body.Read(body)
+		if got := string(body[:n]); got != tt.want {
+			t.Errorf("request %d: response %q, want %q", i, got, tt.want)
+		}
+		if replayed := resp.Header.Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+			t.Errorf("request %d: replayed = %v, want %v", i, replayed, tt.replayed)
+		}
+	}
+}
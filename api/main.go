@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -16,9 +20,16 @@ func main() {
 		log.Println("No .env file found, using system environment variables")
 	}
 
+	// Subcommands such as import-factors run instead of the server
+	if code, handled := runCommand(os.Args[1:]); handled {
+		os.Exit(code)
+	}
+
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
 		AppName: "CarbonAPI v1.0",
+		// Spreadsheet uploads can be larger than the 4 MB default
+		BodyLimit: 32 << 20,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -34,19 +45,26 @@ func main() {
 	// Middleware
 	app.Use(logger.New())
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
+		AllowOrigins:  "*",
+		AllowMethods:  "GET,POST,PUT,DELETE,OPTIONS",
+		AllowHeaders:  "Origin,Content-Type,Accept,Authorization,X-API-Key,Idempotency-Key",
+		ExposeHeaders: "X-RateLimit-Limit,X-RateLimit-Remaining,X-RateLimit-Reset,X-Quota-Limit,X-Quota-Remaining,X-Quota-Reset,Retry-After,Idempotent-Replayed",
 	}))
 
-	// Initialize database and cache
-	db := initDatabase()
+	// Initialize storage and cache
+	store := initStorage()
 	cache := initRedis()
-	defer db.Close()
+	defer store.Close()
 	defer cache.Close()
 
+	// Factor reads go through Redis and a local LRU
+	factorCache := NewFactorCache(store, cache)
+	go factorCache.Listen(context.Background())
+
 	// Initialize services
-	carbonService := NewCarbonService(db, cache)
+	carbonService := NewCarbonService(factorCache, cache)
+	carbonService.outbox.Start()
+	go carbonService.purgeIdempotencyKeys(time.Hour)
 
 	// Routes
 	setupRoutes(app, carbonService)
@@ -66,29 +84,98 @@ func main() {
 		port = "3000"
 	}
 
-	log.Printf("🌱 CarbonAPI starting on port %s", port)
-	log.Fatal(app.Listen(":" + port))
+	go func() {
+		log.Printf("🌱 CarbonAPI starting on port %s", port)
+		if err := app.Listen(":" + port); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGINT/SIGTERM finish in-flight requests, then save queued calculations
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+	if err := carbonService.outbox.Close(ctx); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
 }
 
+const shutdownTimeout = 30 * time.Second
+
 func setupRoutes(app *fiber.App, carbonService *CarbonService) {
-	api := app.Group("/api/v1")
+	// Every route needs a JWT or API key except the docs
+	authConfig := authConfigFromEnv()
+	authConfig.Next = func(c *fiber.Ctx) bool {
+		return c.Path() == "/api/v1/docs"
+	}
+	api := app.Group("/api/v1", carbonService.requireAuth(authConfig), carbonService.limiter.Middleware())
 
 	// Carbon calculation endpoints
-	api.Post("/calculate", carbonService.CalculateCarbon)
+	// Replays skip the quota and usage tracking of the original request
+	idempotent := carbonService.idempotent(idempotencyTTLFromEnv())
+	api.Post("/calculate", requireScope(ScopeCalculate), idempotent, carbonService.limiter.Quota(perRequest),
+		carbonService.trackUsage("calculate"), carbonService.CalculateCarbon)
+	api.Post("/calculate/batch", requireScope(ScopeCalculate), idempotent, carbonService.trackUsage("calculate_batch"),
+		carbonService.CalculateBatch)
+	api.Post("/calculate/uploads", requireScope(ScopeCalculate), carbonService.trackUsage("calculate_upload"),
+		carbonService.CreateUpload)
+	api.Get("/calculate/uploads/:id", requireScope(ScopeCalculate), carbonService.GetUpload)
+	api.Get("/calculate/uploads/:id/result", requireScope(ScopeCalculate), carbonService.GetUploadResult)
+	api.Get("/calculations", requireScope(ScopeCalculate), carbonService.ListCalculations)
+	api.Get("/calculations/:id", requireScope(ScopeCalculate), carbonService.GetCalculation)
 	api.Get("/activities", carbonService.GetActivities)
-	api.Get("/factors", carbonService.GetEmissionFactors)
-	api.Get("/analytics", carbonService.GetAnalytics)
+	api.Get("/factors", requireScope(ScopeReadFactors), carbonService.trackUsage("factors"), carbonService.GetEmissionFactors)
+	api.Get("/grid-intensity", requireScope(ScopeReadFactors), carbonService.trackUsage("grid_intensity"),
+		carbonService.GetGridIntensity)
+	api.Post("/schedule", requireScope(ScopeCalculate), carbonService.trackUsage("schedule"), carbonService.Schedule)
+	api.Get("/analytics", requireScope(ScopeAnalytics), carbonService.trackUsage("analytics"), carbonService.GetAnalytics)
+
+	// Administration
+	admin := api.Group("/admin", requireScope(ScopeAdmin))
+	admin.Post("/api-keys", carbonService.CreateAPIKey)
+	admin.Get("/api-keys", carbonService.ListAPIKeys)
+	admin.Delete("/api-keys/:id", carbonService.RevokeAPIKey)
+	admin.Post("/factors", carbonService.CreateEmissionFactor)
+	admin.Post("/factors/import", carbonService.ImportEmissionFactors)
+	admin.Post("/factors/import/:dataset", carbonService.ImportFactorDataset)
+	admin.Put("/factors/:id", carbonService.UpdateEmissionFactor)
+	admin.Post("/factors/:id/retire", carbonService.RetireEmissionFactor)
+	admin.Post("/grid-intensity", carbonService.ImportGridIntensity)
 
 	// Documentation
 	api.Get("/docs", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"message": "CarbonAPI Documentation",
 			"endpoints": map[string]interface{}{
-				"POST /api/v1/calculate": "Calculate carbon footprint for an activity",
-				"GET /api/v1/activities": "List all supported activities",
-				"GET /api/v1/factors":    "Get emission factors database",
-				"GET /api/v1/analytics":  "Usage analytics and statistics",
-				"GET /health":            "Health check endpoint",
+				"POST /api/v1/calculate":                     "Calculate carbon footprint for an activity; ?sync=true stores it before responding, ?uncertainty=true adds a Monte Carlo range",
+				"POST /api/v1/calculate/batch":               "Calculate up to 1000 line items in one request; ?uncertainty=true adds ranges",
+				"POST /api/v1/calculate/uploads":             "Upload a CSV or XLSX file to calculate in the background",
+				"GET /api/v1/calculate/uploads/:id":          "Poll an upload job for progress and row errors",
+				"GET /api/v1/calculate/uploads/:id/result":   "Download the upload with a carbon_footprint column",
+				"GET /api/v1/calculations":                   "List stored calculations with filters and cursor pagination",
+				"GET /api/v1/calculations/:id":               "Get a stored calculation with its input and result",
+				"GET /api/v1/activities":                     "List all supported activities",
+				"GET /api/v1/factors":                        "Get emission factors database",
+				"GET /api/v1/grid-intensity":                 "Hourly grid carbon intensity of a region",
+				"POST /api/v1/schedule":                      "Recommend the lowest-carbon start time for a flexible job",
+				"GET /api/v1/analytics":                      "Usage analytics and statistics",
+				"POST /api/v1/admin/api-keys":                "Create an API key; the key is only shown once (admin)",
+				"GET /api/v1/admin/api-keys":                 "List API keys (admin)",
+				"DELETE /api/v1/admin/api-keys/:id":          "Revoke an API key (admin)",
+				"POST /api/v1/admin/factors":                 "Create an emission factor (admin)",
+				"POST /api/v1/admin/factors/import":          "Bulk import emission factors (admin)",
+				"POST /api/v1/admin/factors/import/:dataset": "Import a DEFRA or EPA dataset file (admin)",
+				"PUT /api/v1/admin/factors/:id":              "Update an emission factor (admin)",
+				"POST /api/v1/admin/factors/:id/retire":      "Retire an emission factor (admin)",
+				"POST /api/v1/admin/grid-intensity":          "Import hourly grid intensity from CSV (admin)",
+				"GET /health":                                "Health check endpoint",
 			},
 			"example": map[string]interface{}{
 				"url":    "POST /api/v1/calculate",
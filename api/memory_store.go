@@ -0,0 +1,525 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a process-local Storage seeded with the built-in factors.
+// It backs the API when Postgres is unavailable; nothing survives a restart.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	factors       []EmissionFactor
+	nextFactorID  int
+	calculations  []CalculationRecord
+	apiKeys       []APIKey
+	usageRequests int
+	usageTotalMs  int64
+	idempotency   map[string]IdempotencyRecord
+	// intensity holds hourly grid intensity by region and hour
+	intensity map[string]map[time.Time]IntensityPoint
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		nextFactorID: 1,
+		idempotency:  make(map[string]IdempotencyRecord),
+		intensity:    make(map[string]map[time.Time]IntensityPoint),
+	}
+
+	activities := make([]string, 0, len(defaultEmissionFactors))
+	for activity := range defaultEmissionFactors {
+		activities = append(activities, activity)
+	}
+	sort.Strings(activities)
+
+	for _, activity := range activities {
+		for _, mode := range defaultModes(activity) {
+			factor := defaultEmissionFactors[activity][mode]
+			factor.Region = worldRegion
+			factor.Version = defaultFactorVersion
+			s.insert(factor)
+		}
+	}
+	for _, factor := range gridIntensityFactors() {
+		s.insert(factor)
+	}
+	return s
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+// validAt reports whether a factor's [valid_from, valid_to) period covers at.
+func validAt(factor EmissionFactor, at time.Time) bool {
+	if factor.ValidFrom != nil && factor.ValidFrom.After(at) {
+		return false
+	}
+	if factor.ValidTo != nil && !factor.ValidTo.After(at) {
+		return false
+	}
+	return true
+}
+
+// newerFactor orders factors like the Postgres lookup: latest valid_from
+// first with open-ended ones last, then highest id.
+func newerFactor(a, b EmissionFactor) bool {
+	switch {
+	case a.ValidFrom == nil && b.ValidFrom == nil:
+		return a.ID > b.ID
+	case a.ValidFrom == nil:
+		return false
+	case b.ValidFrom == nil:
+		return true
+	case !a.ValidFrom.Equal(b.ValidFrom.Time):
+		return a.ValidFrom.After(b.ValidFrom.Time)
+	}
+	return a.ID > b.ID
+}
+
+func (s *MemoryStore) FindEmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var best *EmissionFactor
+	for i := range s.factors {
+		factor := &s.factors[i]
+		if factor.Activity != q.Activity || factor.Region != q.Region || !validAt(*factor, q.At) {
+			continue
+		}
+		if q.Mode != "" && factor.TransportMode != q.Mode {
+			continue
+		}
+		if best == nil || newerFactor(*factor, *best) {
+			best = factor
+		}
+	}
+	if best == nil {
+		return EmissionFactor{}, ErrNotFound
+	}
+	return *best, nil
+}
+
+func (s *MemoryStore) ListEmissionFactors(at time.Time) ([]EmissionFactor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var factors []EmissionFactor
+	for _, factor := range s.factors {
+		if at.IsZero() || validAt(factor, at) {
+			factors = append(factors, factor)
+		}
+	}
+	sort.SliceStable(factors, func(i, j int) bool {
+		a, b := factors[i], factors[j]
+		if a.Activity != b.Activity {
+			return a.Activity < b.Activity
+		}
+		if a.TransportMode != b.TransportMode {
+			return a.TransportMode < b.TransportMode
+		}
+		if a.ValidFrom == nil || b.ValidFrom == nil {
+			return a.ValidFrom == nil && b.ValidFrom != nil
+		}
+		return a.ValidFrom.Before(b.ValidFrom.Time)
+	})
+	return factors, nil
+}
+
+// conflicts reports whether another factor already uses factor's unique key.
+func (s *MemoryStore) conflicts(factor EmissionFactor, exceptID int) bool {
+	key := factorKey(factor)
+	for _, existing := range s.factors {
+		if existing.ID != exceptID && factorKey(existing) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) insert(factor EmissionFactor) int {
+	factor.ID = s.nextFactorID
+	s.nextFactorID++
+	s.factors = append(s.factors, factor)
+	return factor.ID
+}
+
+func (s *MemoryStore) CreateEmissionFactor(factor EmissionFactor) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conflicts(factor, 0) {
+		return 0, ErrDuplicateFactor
+	}
+	return s.insert(factor), nil
+}
+
+func (s *MemoryStore) UpdateEmissionFactor(id int, factor EmissionFactor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.factors {
+		if s.factors[i].ID != id {
+			continue
+		}
+		if s.conflicts(factor, id) {
+			return ErrDuplicateFactor
+		}
+		factor.ID = id
+		s.factors[i] = factor
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) RetireEmissionFactor(id int, at time.Time) (EmissionFactor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.factors {
+		factor := &s.factors[i]
+		if factor.ID != id {
+			continue
+		}
+		if factor.ValidFrom != nil && !factor.ValidFrom.Before(at) {
+			break
+		}
+		factor.ValidTo = &Date{at}
+		return *factor, nil
+	}
+	return EmissionFactor{}, ErrNotFound
+}
+
+func (s *MemoryStore) ImportEmissionFactors(factors []EmissionFactor, skipDuplicates bool) (*FactorImportResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check everything first so a rejected import writes nothing. Rows are
+	// checked against each other as well as stored factors, as the unique
+	// index does in Postgres.
+	result := &FactorImportResult{}
+	var accepted []EmissionFactor
+	inBatch := make(map[string]bool)
+	for i, factor := range factors {
+		key := factorKey(factor)
+		if !inBatch[key] && !s.conflicts(factor, 0) {
+			inBatch[key] = true
+			accepted = append(accepted, factor)
+			continue
+		}
+		if skipDuplicates {
+			result.Skipped++
+			continue
+		}
+		result.Errors = append(result.Errors, FactorImportError{Row: i + 1, Message: duplicateFactorMessage})
+	}
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	for _, factor := range accepted {
+		result.IDs = append(result.IDs, s.insert(factor))
+		result.Created++
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) SaveCalculation(record CalculationRecord) (int, error) {
+	ids, err := s.SaveCalculations([]CalculationRecord{record})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (s *MemoryStore) SaveCalculations(records []CalculationRecord) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(records))
+	for _, record := range records {
+		if existing, ok := s.findCalculation(record.CalculationID); ok {
+			ids = append(ids, existing.ID)
+			continue
+		}
+		record.ID = len(s.calculations) + 1
+		if record.CreatedAt.IsZero() {
+			record.CreatedAt = time.Now()
+		}
+		s.calculations = append(s.calculations, record)
+		ids = append(ids, record.ID)
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) GetCalculation(id int) (CalculationRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if id < 1 || id > len(s.calculations) {
+		return CalculationRecord{}, ErrNotFound
+	}
+	return s.calculations[id-1], nil
+}
+
+func (s *MemoryStore) FindCalculation(calculationID string) (CalculationRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if record, ok := s.findCalculation(calculationID); ok {
+		return record, nil
+	}
+	return CalculationRecord{}, ErrNotFound
+}
+
+// findCalculation looks up a calculation ID; callers hold s.mu.
+func (s *MemoryStore) findCalculation(calculationID string) (CalculationRecord, bool) {
+	if calculationID == "" {
+		return CalculationRecord{}, false
+	}
+	for _, record := range s.calculations {
+		if record.CalculationID == calculationID {
+			return record, true
+		}
+	}
+	return CalculationRecord{}, false
+}
+
+func (s *MemoryStore) ListCalculations(filter CalculationFilter) ([]CalculationRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// before reports whether a sorts ahead of b in ascending order
+	before := func(a, b CalculationCursor) bool {
+		if filter.Sort == SortCarbonFootprint && a.CarbonFootprint != b.CarbonFootprint {
+			return a.CarbonFootprint < b.CarbonFootprint
+		}
+		if filter.Sort != SortCarbonFootprint && !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	}
+	position := func(record CalculationRecord) CalculationCursor {
+		return CalculationCursor{CreatedAt: record.CreatedAt, CarbonFootprint: record.CarbonFootprint, ID: record.ID}
+	}
+
+	var matched []CalculationRecord
+	for _, record := range s.calculations {
+		switch {
+		case filter.Activity != "" && record.Activity != filter.Activity,
+			filter.UserID != "" && record.UserID != filter.UserID,
+			filter.OrgID != "" && record.OrgID != filter.OrgID,
+			filter.Tag != "" && !containsString(record.Tags, filter.Tag),
+			filter.Scope != 0 && record.Scope != filter.Scope,
+			!filter.From.IsZero() && record.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !record.CreatedAt.Before(filter.To),
+			filter.MinFootprint != nil && record.CarbonFootprint < *filter.MinFootprint,
+			filter.MaxFootprint != nil && record.CarbonFootprint > *filter.MaxFootprint:
+			continue
+		}
+		if filter.After != nil {
+			if filter.Descending && !before(position(record), *filter.After) ||
+				!filter.Descending && !before(*filter.After, position(record)) {
+				continue
+			}
+		}
+		matched = append(matched, record)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if filter.Descending {
+			return before(position(matched[j]), position(matched[i]))
+		}
+		return before(position(matched[i]), position(matched[j]))
+	})
+	if len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, nil
+}
+
+func (s *MemoryStore) RecordAPIUsage(endpoint string, principal Principal, responseTime time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usageRequests++
+	s.usageTotalMs += responseTime.Milliseconds()
+	if principal.APIKeyID != 0 {
+		now := time.Now().UTC()
+		for i := range s.apiKeys {
+			if s.apiKeys[i].ID == principal.APIKeyID {
+				s.apiKeys[i].LastUsedAt = &now
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateAPIKey(key APIKey) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.ID = len(s.apiKeys) + 1
+	key.CreatedAt = time.Now().UTC()
+	s.apiKeys = append(s.apiKeys, key)
+	return key, nil
+}
+
+func (s *MemoryStore) ListAPIKeys() ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]APIKey(nil), s.apiKeys...), nil
+}
+
+func (s *MemoryStore) FindAPIKey(prefix string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.apiKeys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (s *MemoryStore) RevokeAPIKey(id int, at time.Time) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.apiKeys {
+		key := &s.apiKeys[i]
+		if key.ID == id && key.RevokedAt == nil {
+			key.RevokedAt = &at
+			return *key, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (s *MemoryStore) Analytics() (*UsageAnalytics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	analytics := &UsageAnalytics{
+		TotalCalculations: len(s.calculations),
+		EmissionsByScope:  make(map[string]float64),
+		Scope3ByCategory:  make(map[int]float64),
+	}
+	counts := make(map[string]int)
+	for _, record := range s.calculations {
+		analytics.TotalCarbonCalculated += record.CarbonFootprint
+		counts[record.Activity]++
+		analytics.EmissionsByScope[scopeKey(record.Scope)] += record.CarbonFootprint
+		if record.Scope == Scope3 {
+			analytics.Scope3ByCategory[record.Scope3Category] += record.CarbonFootprint
+		}
+	}
+	if s.usageRequests > 0 {
+		analytics.AvgResponseTimeMs = float64(s.usageTotalMs) / float64(s.usageRequests)
+	}
+
+	// Same top five as the SQL version
+	activities := make([]string, 0, len(counts))
+	for activity := range counts {
+		activities = append(activities, activity)
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if counts[activities[i]] != counts[activities[j]] {
+			return counts[activities[i]] > counts[activities[j]]
+		}
+		return activities[i] < activities[j]
+	})
+	if len(activities) > 5 {
+		activities = activities[:5]
+	}
+	analytics.TopActivities = make(map[string]int, len(activities))
+	for _, activity := range activities {
+		analytics.TopActivities[activity] = counts[activity]
+	}
+	return analytics, nil
+}
+
+func (s *MemoryStore) ReserveIdempotencyKey(record IdempotencyRecord, staleBefore time.Time) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := record.Owner + "\x00" + record.Key
+	if existing, ok := s.idempotency[id]; ok {
+		expired := !existing.ExpiresAt.After(record.CreatedAt)
+		stale := existing.CompletedAt == nil && existing.CreatedAt.Before(staleBefore)
+		if !expired && !stale {
+			return existing, false, nil
+		}
+	}
+	s.idempotency[id] = record
+	return record, true, nil
+}
+
+func (s *MemoryStore) CompleteIdempotencyKey(record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := record.Owner + "\x00" + record.Key
+	if _, ok := s.idempotency[id]; !ok {
+		return ErrNotFound
+	}
+	s.idempotency[id] = record
+	return nil
+}
+
+func (s *MemoryStore) ReleaseIdempotencyKey(owner, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := owner + "\x00" + key
+	if existing, ok := s.idempotency[id]; ok && existing.CompletedAt == nil {
+		delete(s.idempotency, id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) PurgeIdempotencyKeys(at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, record := range s.idempotency {
+		if record.ExpiresAt.Before(at) {
+			delete(s.idempotency, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryStore) SaveGridIntensity(points []IntensityPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, point := range points {
+		point.Hour = point.Hour.UTC()
+		hours, ok := s.intensity[point.Region]
+		if !ok {
+			hours = make(map[time.Time]IntensityPoint)
+			s.intensity[point.Region] = hours
+		}
+		hours[point.Hour] = point
+	}
+	return nil
+}
+
+func (s *MemoryStore) GridIntensity(region string, from, to time.Time) ([]IntensityPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var points []IntensityPoint
+	for hour, point := range s.intensity[region] {
+		if !hour.Before(from) && hour.Before(to) {
+			points = append(points, point)
+		}
+	}
+	sortIntensity(points)
+	return points, nil
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS api_usage;
+DROP TABLE IF EXISTS calculations;
+DROP TABLE IF EXISTS emission_factors;
@@ -0,0 +1,29 @@
+-- Base schema. IF NOT EXISTS lets databases created by the old createTables
+-- bootstrap adopt the migration history.
+CREATE TABLE IF NOT EXISTS emission_factors (
+	id SERIAL PRIMARY KEY,
+	activity VARCHAR(100) NOT NULL,
+	transport_mode VARCHAR(50),
+	factor DECIMAL(10,6) NOT NULL,
+	unit VARCHAR(20) NOT NULL,
+	source VARCHAR(100),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS calculations (
+	id SERIAL PRIMARY KEY,
+	activity VARCHAR(100) NOT NULL,
+	input_data JSONB NOT NULL,
+	carbon_footprint DECIMAL(10,6) NOT NULL,
+	unit VARCHAR(20) NOT NULL,
+	user_id VARCHAR(100),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_usage (
+	id SERIAL PRIMARY KEY,
+	endpoint VARCHAR(100) NOT NULL,
+	user_id VARCHAR(100),
+	response_time_ms INTEGER,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_emission_factors_lookup;
+
+ALTER TABLE calculations DROP COLUMN IF EXISTS activity_date;
+ALTER TABLE calculations DROP COLUMN IF EXISTS factors;
+ALTER TABLE calculations DROP COLUMN IF EXISTS factor_version;
+ALTER TABLE calculations DROP COLUMN IF EXISTS factor_id;
+
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS valid_to;
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS valid_from;
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS version;
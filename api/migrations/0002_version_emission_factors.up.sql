@@ -0,0 +1,11 @@
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS version VARCHAR(50) NOT NULL DEFAULT '2023';
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS valid_from DATE;
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS valid_to DATE;
+
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS factor_id INTEGER;
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS factor_version VARCHAR(50);
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS factors JSONB;
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS activity_date DATE;
+
+CREATE INDEX IF NOT EXISTS idx_emission_factors_lookup
+	ON emission_factors (activity, transport_mode, valid_from);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_emission_factors_unique;
+DROP INDEX IF EXISTS idx_emission_factors_lookup;
+CREATE INDEX idx_emission_factors_lookup
+	ON emission_factors (activity, transport_mode, valid_from);
+
+ALTER TABLE emission_factors ALTER COLUMN transport_mode DROP NOT NULL;
+ALTER TABLE emission_factors ALTER COLUMN transport_mode DROP DEFAULT;
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS region;
@@ -0,0 +1,12 @@
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS region VARCHAR(50) NOT NULL DEFAULT 'WORLD';
+
+-- transport_mode takes part in the unique key, so it cannot be NULL
+UPDATE emission_factors SET transport_mode = '' WHERE transport_mode IS NULL;
+ALTER TABLE emission_factors ALTER COLUMN transport_mode SET DEFAULT '';
+ALTER TABLE emission_factors ALTER COLUMN transport_mode SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_emission_factors_lookup;
+CREATE INDEX idx_emission_factors_lookup
+	ON emission_factors (activity, transport_mode, region, valid_from);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_emission_factors_unique
+	ON emission_factors (activity, transport_mode, region, version);
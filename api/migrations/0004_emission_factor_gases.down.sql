@@ -0,0 +1,4 @@
+-- transport_mode is left at VARCHAR(100): narrowing could truncate imported rows
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS n2o_factor;
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS ch4_factor;
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS co2_factor;
@@ -0,0 +1,6 @@
+-- Imported datasets have long hierarchical mode names
+ALTER TABLE emission_factors ALTER COLUMN transport_mode TYPE VARCHAR(100);
+
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS co2_factor DOUBLE PRECISION;
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS ch4_factor DOUBLE PRECISION;
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS n2o_factor DOUBLE PRECISION;
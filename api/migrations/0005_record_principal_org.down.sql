@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_api_usage_org;
+DROP INDEX IF EXISTS idx_calculations_org;
+
+ALTER TABLE api_usage DROP COLUMN IF EXISTS org_id;
+ALTER TABLE calculations DROP COLUMN IF EXISTS org_id;
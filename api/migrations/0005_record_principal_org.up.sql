@@ -0,0 +1,6 @@
+-- Calculations and usage are attributed to the authenticated subject and org
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS org_id VARCHAR(100);
+ALTER TABLE api_usage ADD COLUMN IF NOT EXISTS org_id VARCHAR(100);
+
+CREATE INDEX IF NOT EXISTS idx_calculations_org ON calculations (org_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_api_usage_org ON api_usage (org_id, created_at);
@@ -0,0 +1,16 @@
+-- Only a salted SHA-256 of the secret is stored; prefix is the public lookup key
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	prefix VARCHAR(20) NOT NULL UNIQUE,
+	salt BYTEA NOT NULL,
+	hash BYTEA NOT NULL,
+	org_id VARCHAR(100),
+	scopes TEXT[] NOT NULL,
+	created_by VARCHAR(100),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+);
+
+ALTER TABLE api_usage ADD COLUMN IF NOT EXISTS api_key_id INTEGER REFERENCES api_keys (id);
@@ -0,0 +1,2 @@
+-- Rate limits and monthly quotas are set per plan
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS plan VARCHAR(50) NOT NULL DEFAULT 'free';
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_calculations_tags;
+DROP INDEX IF EXISTS idx_calculations_user;
+DROP INDEX IF EXISTS idx_calculations_created;
+
+ALTER TABLE calculations DROP COLUMN IF EXISTS tags;
+ALTER TABLE calculations DROP COLUMN IF EXISTS result;
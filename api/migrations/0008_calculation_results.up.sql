@@ -0,0 +1,7 @@
+-- Keep the full response and client tags so calculations can be read back
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS result JSONB;
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_calculations_created ON calculations (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_calculations_user ON calculations (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_calculations_tags ON calculations USING GIN (tags);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_calculations_calculation_id;
+ALTER TABLE calculations DROP COLUMN IF EXISTS calculation_id;
@@ -0,0 +1,4 @@
+-- Calculations get their public ID when computed, before they are stored,
+-- so the outbox can retry an insert without duplicating it
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS calculation_id UUID;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_calculations_calculation_id ON calculations (calculation_id);
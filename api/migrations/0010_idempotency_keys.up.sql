@@ -0,0 +1,15 @@
+-- Responses to requests sent with an Idempotency-Key, replayed on retry
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	owner VARCHAR(255) NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	request_hash BYTEA NOT NULL,
+	status_code INTEGER,
+	content_type VARCHAR(100),
+	body BYTEA,
+	created_at TIMESTAMP NOT NULL,
+	completed_at TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (owner, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires_at);
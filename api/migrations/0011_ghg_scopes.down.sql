@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_calculations_scope;
+
+ALTER TABLE calculations DROP COLUMN IF EXISTS scope3_category;
+ALTER TABLE calculations DROP COLUMN IF EXISTS ghg_scope;
+
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS scope3_category;
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS ghg_scope;
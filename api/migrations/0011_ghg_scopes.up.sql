@@ -0,0 +1,23 @@
+-- GHG Protocol scope (1-3) and Scope 3 category (1-15) of factors and results
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS ghg_scope SMALLINT CHECK (ghg_scope BETWEEN 1 AND 3);
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS scope3_category SMALLINT CHECK (scope3_category BETWEEN 1 AND 15);
+
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS ghg_scope SMALLINT;
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS scope3_category SMALLINT;
+
+-- Classify past calculations of the built-in activities the way new ones are
+UPDATE calculations SET
+	ghg_scope = CASE activity
+		WHEN 'fuel' THEN 1
+		WHEN 'electricity' THEN 2
+		ELSE 3
+	END,
+	scope3_category = CASE activity
+		WHEN 'flight' THEN 6
+		WHEN 'fuel' THEN NULL
+		WHEN 'electricity' THEN NULL
+		ELSE 4
+	END
+WHERE ghg_scope IS NULL AND activity IN ('fuel', 'electricity', 'shipping', 'shipment', 'flight');
+
+CREATE INDEX IF NOT EXISTS idx_calculations_scope ON calculations (ghg_scope, scope3_category);
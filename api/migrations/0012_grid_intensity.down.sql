@@ -0,0 +1 @@
+DROP TABLE IF EXISTS grid_intensity;
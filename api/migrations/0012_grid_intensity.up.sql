@@ -0,0 +1,9 @@
+-- Hourly grid carbon intensity per region, in kg CO2e per kWh
+CREATE TABLE IF NOT EXISTS grid_intensity (
+	region VARCHAR(50) NOT NULL,
+	hour TIMESTAMP NOT NULL,
+	intensity DOUBLE PRECISION NOT NULL CHECK (intensity >= 0),
+	source VARCHAR(100),
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (region, hour)
+);
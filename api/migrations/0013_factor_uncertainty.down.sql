@@ -0,0 +1 @@
+ALTER TABLE emission_factors DROP COLUMN IF EXISTS uncertainty;
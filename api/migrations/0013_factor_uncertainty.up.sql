@@ -0,0 +1,3 @@
+-- Uncertainty of a factor as {"distribution": ..., parameters}; NULL means
+-- the activity's default range applies
+ALTER TABLE emission_factors ADD COLUMN IF NOT EXISTS uncertainty JSONB;
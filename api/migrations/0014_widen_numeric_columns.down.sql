@@ -0,0 +1,3 @@
+-- Fails rather than truncates if a footprint of 10,000 or more was stored
+ALTER TABLE emission_factors ALTER COLUMN factor TYPE DECIMAL(10,6);
+ALTER TABLE calculations ALTER COLUMN carbon_footprint TYPE DECIMAL(10,6);
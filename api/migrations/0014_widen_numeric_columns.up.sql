@@ -0,0 +1,5 @@
+-- DECIMAL(10,6) tops out below 10,000, too small for freight or annual
+-- footprints. Factors match the gas factor columns; footprints stay exact
+-- so totals add up.
+ALTER TABLE calculations ALTER COLUMN carbon_footprint TYPE NUMERIC(18,6);
+ALTER TABLE emission_factors ALTER COLUMN factor TYPE DOUBLE PRECISION;
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	outboxKey           = "calculations:outbox"
+	outboxProcessingKey = "calculations:outbox:processing"
+	// outboxFailedKey held entries that ran out of retries in earlier
+	// versions; Start requeues them.
+	outboxFailedKey = "calculations:outbox:failed"
+
+	outboxWorkers       = 4
+	outboxBuffer        = 10000
+	outboxRetryDelay    = 500 * time.Millisecond
+	outboxMaxRetryDelay = 30 * time.Second
+	// outboxSpillTimeout bounds how long Close waits for entries it could
+	// not save to be handed back to Redis or logged.
+	outboxSpillTimeout = 5 * time.Second
+)
+
+// outboxEntry is a group of calculations saved in one transaction.
+type outboxEntry struct {
+	Records []CalculationRecord `json:"records"`
+}
+
+// CalculationOutbox persists calculations off the request path. Entries are
+// queued in a Redis list so they survive a crash, or in process memory while
+// Redis is unavailable, and workers save them, retrying until the store is
+// back. Delivery is at least once; records carry their calculation ID so a
+// repeated save is a no-op.
+type CalculationOutbox struct {
+	store   Storage
+	redis   *redis.Client
+	breaker redisBreaker
+
+	mu     sync.RWMutex
+	closed bool
+	local  chan outboxEntry
+	stop   chan struct{}
+	// abandon is closed when Close runs out of time; local entries not
+	// saved by then are spilled.
+	abandon chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewCalculationOutbox(store Storage, client *redis.Client) *CalculationOutbox {
+	return &CalculationOutbox{
+		store:   store,
+		redis:   client,
+		breaker: redisBreaker{name: "Calculation outbox"},
+		local:   make(chan outboxEntry, outboxBuffer),
+		stop:    make(chan struct{}),
+		abandon: make(chan struct{}),
+	}
+}
+
+// Start requeues entries left in flight by a previous run and starts the
+// workers. An entry requeued while another instance is still saving it is
+// simply saved twice.
+func (o *CalculationOutbox) Start() {
+	if o.redis != nil {
+		ctx := context.Background()
+		for _, key := range []string{outboxProcessingKey, outboxFailedKey} {
+			for {
+				err := o.redis.RPopLPush(ctx, key, outboxKey).Err()
+				if err != nil {
+					if !errors.Is(err, redis.Nil) {
+						o.breaker.failed(err)
+					}
+					break
+				}
+			}
+		}
+	}
+
+	for i := 0; i < outboxWorkers; i++ {
+		o.wg.Add(2)
+		go o.localWorker()
+		go o.redisWorker()
+	}
+}
+
+// Enqueue queues records to be saved together.
+func (o *CalculationOutbox) Enqueue(records []CalculationRecord) {
+	entry := outboxEntry{Records: records}
+
+	if o.useRedis() {
+		data, err := json.Marshal(entry)
+		if err == nil {
+			err = o.redis.LPush(context.Background(), outboxKey, data).Err()
+		}
+		if err == nil {
+			return
+		}
+		o.breaker.failed(err)
+	}
+
+	if o.enqueueLocal(entry) {
+		return
+	}
+	// Full or shut down: save on the caller's goroutine instead, without
+	// holding it up for retries
+	if _, err := o.store.SaveCalculations(entry.Records); err != nil {
+		log.Printf("Failed to store %d calculations inline: %v", len(entry.Records), err)
+		o.spill(entry)
+	}
+}
+
+func (o *CalculationOutbox) enqueueLocal(entry outboxEntry) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.closed {
+		return false
+	}
+	select {
+	case o.local <- entry:
+		return true
+	default:
+		log.Printf("Calculation outbox full, saving %d records inline", len(entry.Records))
+		return false
+	}
+}
+
+// Close stops taking entries from Redis, saves everything queued in
+// process memory and waits for the workers until ctx ends.
+func (o *CalculationOutbox) Close(ctx context.Context) error {
+	o.mu.Lock()
+	if !o.closed {
+		o.closed = true
+		close(o.stop)
+		close(o.local)
+	}
+	o.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Stop retrying and spill what is left rather than lose it
+	close(o.abandon)
+	select {
+	case <-done:
+	case <-time.After(outboxSpillTimeout):
+	}
+	return errors.New("calculation outbox did not drain before shutdown")
+}
+
+func (o *CalculationOutbox) useRedis() bool {
+	return o.redis != nil && o.breaker.available()
+}
+
+func (o *CalculationOutbox) localWorker() {
+	defer o.wg.Done()
+	for entry := range o.local {
+		if !o.deliver(entry, o.abandon) {
+			o.spill(entry)
+		}
+	}
+}
+
+// redisWorker moves one entry at a time to the processing list, so an entry
+// being saved when the process dies is requeued by the next Start.
+func (o *CalculationOutbox) redisWorker() {
+	defer o.wg.Done()
+	if o.redis == nil {
+		return
+	}
+	ctx := context.Background()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		default:
+		}
+		if !o.breaker.available() {
+			select {
+			case <-o.stop:
+				return
+			case <-time.After(time.Second):
+				continue
+			}
+		}
+
+		data, err := o.redis.BRPopLPush(ctx, outboxKey, outboxProcessingKey, time.Second).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			o.breaker.failed(err)
+			continue
+		}
+
+		var entry outboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			log.Printf("Dropping unreadable calculation outbox entry: %v", err)
+		} else if !o.deliver(entry, o.stop) {
+			// Shutting down: the entry stays in the processing list and is
+			// requeued by the next Start
+			return
+		}
+		if err := o.redis.LRem(ctx, outboxProcessingKey, 1, data).Err(); err != nil {
+			o.breaker.failed(err)
+		}
+	}
+}
+
+// deliver saves an entry, retrying with capped backoff for as long as it
+// takes. It reports false only if giveUp is closed first.
+func (o *CalculationOutbox) deliver(entry outboxEntry, giveUp <-chan struct{}) bool {
+	delay := outboxRetryDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-giveUp:
+			return false
+		default:
+		}
+		_, err := o.store.SaveCalculations(entry.Records)
+		if err == nil {
+			return true
+		}
+		log.Printf("Failed to store %d calculations (attempt %d), retrying in %v: %v", len(entry.Records), attempt, delay, err)
+
+		select {
+		case <-giveUp:
+			return false
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, outboxMaxRetryDelay)
+	}
+}
+
+// spill hands an entry that could not be saved back to the Redis queue, or
+// failing that logs its records so they can be recovered by hand.
+func (o *CalculationOutbox) spill(entry outboxEntry) {
+	data, err := json.Marshal(entry)
+	if err == nil && o.useRedis() {
+		if err = o.redis.LPush(context.Background(), outboxKey, data).Err(); err == nil {
+			return
+		}
+		o.breaker.failed(err)
+	}
+	log.Printf("Failed to store %d calculations, recover them from this log: %s", len(entry.Records), data)
+}
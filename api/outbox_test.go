@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore fails as many saves as failures before it starts saving.
+type flakyStore struct {
+	Storage
+	mu       sync.Mutex
+	failures int
+	saved    int
+}
+
+func (s *flakyStore) SaveCalculations(records []CalculationRecord) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("database unavailable")
+	}
+	s.saved += len(records)
+	return make([]int, len(records)), nil
+}
+
+func TestOutboxRetriesUntilSaved(t *testing.T) {
+	store := &flakyStore{Storage: NewMemoryStore(), failures: 2}
+	outbox := NewCalculationOutbox(store, nil)
+	outbox.Start()
+	outbox.Enqueue([]CalculationRecord{{}, {}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := outbox.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.saved != 2 {
+		t.Errorf("saved %d records, want 2", store.saved)
+	}
+}
+
+func TestOutboxCloseGivesUpOnUnavailableStore(t *testing.T) {
+	store := &flakyStore{Storage: NewMemoryStore(), failures: 1 << 30}
+	outbox := NewCalculationOutbox(store, nil)
+	outbox.Start()
+	outbox.Enqueue([]CalculationRecord{{}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := outbox.Close(ctx); err == nil {
+		t.Fatal("Close reported a full drain with the store down")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v after its deadline", elapsed)
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresStore implements Storage on the migrated Postgres schema.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func nullableDate(d *Date) sql.NullTime {
+	if d == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: d.Time, Valid: true}
+}
+
+func nullableString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *PostgresStore) FindEmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	query := `
+		SELECT ` + emissionFactorColumns + `
+		FROM emission_factors
+		WHERE activity = $1
+		  AND region = $2
+		  AND (valid_from IS NULL OR valid_from <= $3)
+		  AND (valid_to IS NULL OR valid_to > $3)
+	`
+
+	args := []interface{}{q.Activity, q.Region, q.At}
+
+	if q.Mode != "" {
+		query += " AND transport_mode = $4"
+		args = append(args, q.Mode)
+	}
+
+	query += " ORDER BY valid_from DESC NULLS LAST, id DESC LIMIT 1"
+
+	factor, err := scanEmissionFactor(s.db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return factor, ErrNotFound
+	}
+	return factor, err
+}
+
+func (s *PostgresStore) ListEmissionFactors(at time.Time) ([]EmissionFactor, error) {
+	query := `SELECT ` + emissionFactorColumns + ` FROM emission_factors`
+	var args []interface{}
+
+	if !at.IsZero() {
+		query += ` WHERE (valid_from IS NULL OR valid_from <= $1) AND (valid_to IS NULL OR valid_to > $1)`
+		args = append(args, at)
+	}
+	query += ` ORDER BY activity, transport_mode, valid_from NULLS FIRST`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var factors []EmissionFactor
+	for rows.Next() {
+		factor, err := scanEmissionFactor(rows)
+		if err != nil {
+			return nil, err
+		}
+		factors = append(factors, factor)
+	}
+	return factors, rows.Err()
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func insertEmissionFactor(db queryRower, factor EmissionFactor) (int, error) {
+	var id int
+	co2, ch4, n2o := factor.gasColumns()
+	scope, category := factor.scopeColumns()
+	uncertainty, err := factor.uncertaintyColumn()
+	if err != nil {
+		return 0, err
+	}
+	err = db.QueryRow(`
+		INSERT INTO emission_factors (activity, transport_mode, region, factor, unit, source, version, valid_from, valid_to,
+			co2_factor, ch4_factor, n2o_factor, ghg_scope, scope3_category, uncertainty)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id
+	`, factor.Activity, factor.TransportMode, factor.Region, factor.Factor, factor.Unit, factor.Source,
+		factor.Version, nullableDate(factor.ValidFrom), nullableDate(factor.ValidTo), co2, ch4, n2o,
+		scope, category, uncertainty).Scan(&id)
+	return id, err
+}
+
+func (s *PostgresStore) CreateEmissionFactor(factor EmissionFactor) (int, error) {
+	id, err := insertEmissionFactor(s.db, factor)
+	if isUniqueViolation(err) {
+		return 0, ErrDuplicateFactor
+	}
+	return id, err
+}
+
+func (s *PostgresStore) UpdateEmissionFactor(id int, factor EmissionFactor) error {
+	co2, ch4, n2o := factor.gasColumns()
+	scope, category := factor.scopeColumns()
+	uncertainty, err := factor.uncertaintyColumn()
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`
+		UPDATE emission_factors
+		SET activity = $1, transport_mode = $2, region = $3, factor = $4, unit = $5,
+		    source = $6, version = $7, valid_from = $8, valid_to = $9,
+		    co2_factor = $10, ch4_factor = $11, n2o_factor = $12, ghg_scope = $13, scope3_category = $14,
+		    uncertainty = $15
+		WHERE id = $16
+	`, factor.Activity, factor.TransportMode, factor.Region, factor.Factor, factor.Unit, factor.Source,
+		factor.Version, nullableDate(factor.ValidFrom), nullableDate(factor.ValidTo), co2, ch4, n2o,
+		scope, category, uncertainty, id)
+	if isUniqueViolation(err) {
+		return ErrDuplicateFactor
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) RetireEmissionFactor(id int, at time.Time) (EmissionFactor, error) {
+	factor, err := scanEmissionFactor(s.db.QueryRow(`
+		UPDATE emission_factors
+		SET valid_to = $1
+		WHERE id = $2 AND (valid_from IS NULL OR valid_from < $1)
+		RETURNING `+emissionFactorColumns, at, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return factor, ErrNotFound
+	}
+	return factor, err
+}
+
+func (s *PostgresStore) ImportEmissionFactors(factors []EmissionFactor, skipDuplicates bool) (*FactorImportResult, error) {
+	result := &FactorImportResult{}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, factor := range factors {
+		// Savepoints let a duplicate be skipped without aborting the transaction
+		if _, err := tx.Exec("SAVEPOINT factor_row"); err != nil {
+			return nil, err
+		}
+		id, err := insertEmissionFactor(tx, factor)
+		if isUniqueViolation(err) {
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT factor_row"); err != nil {
+				return nil, err
+			}
+			if skipDuplicates {
+				result.Skipped++
+				continue
+			}
+			result.Errors = append(result.Errors, FactorImportError{Row: i + 1, Message: duplicateFactorMessage})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Created++
+		result.IDs = append(result.IDs, id)
+	}
+
+	if len(result.Errors) > 0 {
+		result.Created = 0
+		result.IDs = nil
+		return result, nil
+	}
+
+	return result, tx.Commit()
+}
+
+func insertCalculation(db queryRower, record CalculationRecord) (int, error) {
+	inputJSON, err := json.Marshal(record.Input)
+	if err != nil {
+		return 0, err
+	}
+	factorsJSON, err := json.Marshal(record.Factors)
+	if err != nil {
+		return 0, err
+	}
+	var resultJSON []byte
+	if record.Result != nil {
+		if resultJSON, err = json.Marshal(record.Result); err != nil {
+			return 0, err
+		}
+	}
+	tags := record.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	// The first factor looked up is the primary one; all are kept in factors.
+	var factorID sql.NullInt64
+	var factorVersion sql.NullString
+	if len(record.Factors) > 0 && record.Factors[0].ID != 0 {
+		factorID = sql.NullInt64{Int64: int64(record.Factors[0].ID), Valid: true}
+		factorVersion = sql.NullString{String: record.Factors[0].Version, Valid: true}
+	}
+
+	// A retried save of the same calculation returns the stored row's ID
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO calculations (calculation_id, activity, input_data, carbon_footprint, unit, user_id, org_id,
+			factor_id, factor_version, factors, activity_date, result, tags, ghg_scope, scope3_category)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (calculation_id) DO UPDATE SET calculation_id = EXCLUDED.calculation_id
+		RETURNING id
+	`, nullableString(record.CalculationID), record.Activity, inputJSON, record.CarbonFootprint, record.Unit,
+		record.UserID, nullableString(record.OrgID), factorID, factorVersion, factorsJSON,
+		nullableDate(record.ActivityDate), resultJSON, pq.Array(tags),
+		sql.NullInt64{Int64: int64(record.Scope), Valid: record.Scope != 0},
+		sql.NullInt64{Int64: int64(record.Scope3Category), Valid: record.Scope3Category != 0}).Scan(&id)
+	return id, err
+}
+
+func (s *PostgresStore) SaveCalculation(record CalculationRecord) (int, error) {
+	return insertCalculation(s.db, record)
+}
+
+func (s *PostgresStore) SaveCalculations(records []CalculationRecord) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, 0, len(records))
+	for _, record := range records {
+		id, err := insertCalculation(tx, record)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, tx.Commit()
+}
+
+const calculationColumns = `id, calculation_id, activity, input_data, carbon_footprint, unit, user_id, org_id, factors, activity_date,
+	tags, ghg_scope, scope3_category, result, created_at`
+
+func scanCalculation(row rowScanner) (CalculationRecord, error) {
+	var record CalculationRecord
+	var input, factors, result []byte
+	var calculationID, userID, orgID sql.NullString
+	var activityDate sql.NullTime
+	var scope, category sql.NullInt64
+
+	err := row.Scan(&record.ID, &calculationID, &record.Activity, &input, &record.CarbonFootprint, &record.Unit, &userID, &orgID,
+		&factors, &activityDate, pq.Array(&record.Tags), &scope, &category, &result, &record.CreatedAt)
+	if err != nil {
+		return record, err
+	}
+
+	record.CalculationID = calculationID.String
+	record.UserID = userID.String
+	record.OrgID = orgID.String
+	record.Scope = int(scope.Int64)
+	record.Scope3Category = int(category.Int64)
+	if activityDate.Valid {
+		record.ActivityDate = &Date{activityDate.Time}
+	}
+	if err := json.Unmarshal(input, &record.Input); err != nil {
+		return record, err
+	}
+	if len(factors) > 0 {
+		if err := json.Unmarshal(factors, &record.Factors); err != nil {
+			return record, err
+		}
+	}
+	if len(result) > 0 {
+		record.Result = &CalculateResponse{}
+		if err := json.Unmarshal(result, record.Result); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+func (s *PostgresStore) GetCalculation(id int) (CalculationRecord, error) {
+	record, err := scanCalculation(s.db.QueryRow(`SELECT `+calculationColumns+` FROM calculations WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return record, ErrNotFound
+	}
+	return record, err
+}
+
+func (s *PostgresStore) FindCalculation(calculationID string) (CalculationRecord, error) {
+	record, err := scanCalculation(s.db.QueryRow(
+		`SELECT `+calculationColumns+` FROM calculations WHERE calculation_id = $1`, calculationID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return record, ErrNotFound
+	}
+	return record, err
+}
+
+func (s *PostgresStore) ListCalculations(filter CalculationFilter) ([]CalculationRecord, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Activity != "" {
+		where("activity = $%d", filter.Activity)
+	}
+	if filter.UserID != "" {
+		where("user_id = $%d", filter.UserID)
+	}
+	if filter.OrgID != "" {
+		where("org_id = $%d", filter.OrgID)
+	}
+	if filter.Tag != "" {
+		where("$%d = ANY(tags)", filter.Tag)
+	}
+	if filter.Scope != 0 {
+		where("ghg_scope = $%d", filter.Scope)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+	if filter.MinFootprint != nil {
+		where("carbon_footprint >= $%d", *filter.MinFootprint)
+	}
+	if filter.MaxFootprint != nil {
+		where("carbon_footprint <= $%d", *filter.MaxFootprint)
+	}
+
+	column := "created_at"
+	if filter.Sort == SortCarbonFootprint {
+		column = "carbon_footprint"
+	}
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.After != nil {
+		var value interface{} = filter.After.CreatedAt
+		if filter.Sort == SortCarbonFootprint {
+			value = filter.After.CarbonFootprint
+		}
+		args = append(args, value, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, comparison, len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + calculationColumns + ` FROM calculations`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", column, direction, direction, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []CalculationRecord
+	for rows.Next() {
+		record, err := scanCalculation(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *PostgresStore) RecordAPIUsage(endpoint string, principal Principal, responseTime time.Duration) error {
+	apiKeyID := sql.NullInt64{Int64: int64(principal.APIKeyID), Valid: principal.APIKeyID != 0}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO api_usage (endpoint, user_id, org_id, api_key_id, response_time_ms)
+		VALUES ($1, $2, $3, $4, $5)
+	`, endpoint, principal.Subject, nullableString(principal.Org), apiKeyID, responseTime.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if apiKeyID.Valid {
+		if _, err := tx.Exec(`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, apiKeyID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const apiKeyColumns = `id, name, prefix, salt, hash, org_id, scopes, plan, created_by, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var orgID, createdBy sql.NullString
+	var lastUsed, revoked sql.NullTime
+
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Salt, &key.Hash, &orgID, pq.Array(&key.Scopes),
+		&key.Plan, &createdBy, &key.CreatedAt, &lastUsed, &revoked)
+	if err != nil {
+		return key, err
+	}
+
+	key.OrgID = orgID.String
+	key.CreatedBy = createdBy.String
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		key.RevokedAt = &revoked.Time
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) CreateAPIKey(key APIKey) (APIKey, error) {
+	return scanAPIKey(s.db.QueryRow(`
+		INSERT INTO api_keys (name, prefix, salt, hash, org_id, scopes, plan, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+apiKeyColumns,
+		key.Name, key.Prefix, key.Salt, key.Hash, nullableString(key.OrgID), pq.Array(key.Scopes), key.Plan,
+		nullableString(key.CreatedBy)))
+}
+
+func (s *PostgresStore) ListAPIKeys() ([]APIKey, error) {
+	rows, err := s.db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresStore) FindAPIKey(prefix string) (APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNotFound
+	}
+	return key, err
+}
+
+func (s *PostgresStore) RevokeAPIKey(id int, at time.Time) (APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow(`
+		UPDATE api_keys SET revoked_at = $1
+		WHERE id = $2 AND revoked_at IS NULL
+		RETURNING `+apiKeyColumns, at, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNotFound
+	}
+	return key, err
+}
+
+func (s *PostgresStore) Analytics() (*UsageAnalytics, error) {
+	analytics := &UsageAnalytics{
+		TopActivities:    make(map[string]int),
+		EmissionsByScope: make(map[string]float64),
+		Scope3ByCategory: make(map[int]float64),
+	}
+
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(carbon_footprint), 0) FROM calculations
+	`).Scan(&analytics.TotalCalculations, &analytics.TotalCarbonCalculated)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRow(`SELECT COALESCE(AVG(response_time_ms), 0) FROM api_usage`).Scan(&analytics.AvgResponseTimeMs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT activity, COUNT(*) as count
+		FROM calculations
+		GROUP BY activity
+		ORDER BY count DESC
+		LIMIT 5
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var activity string
+		var count int
+		if err := rows.Scan(&activity, &count); err != nil {
+			return nil, err
+		}
+		analytics.TopActivities[activity] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	scopeRows, err := s.db.Query(`
+		SELECT COALESCE(ghg_scope, 0), COALESCE(scope3_category, 0), SUM(carbon_footprint)
+		FROM calculations
+		GROUP BY 1, 2
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer scopeRows.Close()
+
+	for scopeRows.Next() {
+		var scope, category int
+		var total float64
+		if err := scopeRows.Scan(&scope, &category, &total); err != nil {
+			return nil, err
+		}
+		analytics.EmissionsByScope[scopeKey(scope)] += total
+		if scope == Scope3 {
+			analytics.Scope3ByCategory[category] += total
+		}
+	}
+	return analytics, scopeRows.Err()
+}
+
+func (s *PostgresStore) ReserveIdempotencyKey(record IdempotencyRecord, staleBefore time.Time) (IdempotencyRecord, bool, error) {
+	// The row may vanish between the insert and the read; try again then
+	for attempt := 0; attempt < 3; attempt++ {
+		result, err := s.db.Exec(`
+			INSERT INTO idempotency_keys (owner, key, request_hash, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (owner, key) DO UPDATE SET
+				request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, body = NULL,
+				created_at = EXCLUDED.created_at, completed_at = NULL, expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+			   OR (idempotency_keys.completed_at IS NULL AND idempotency_keys.created_at < $6)
+		`, record.Owner, record.Key, record.RequestHash, record.CreatedAt, record.ExpiresAt, staleBefore)
+		if err != nil {
+			return IdempotencyRecord{}, false, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 1 {
+			return record, err == nil, err
+		}
+
+		var existing IdempotencyRecord
+		var statusCode sql.NullInt64
+		var contentType sql.NullString
+		var completedAt sql.NullTime
+		err = s.db.QueryRow(`
+			SELECT owner, key, request_hash, status_code, content_type, body, created_at, completed_at, expires_at
+			FROM idempotency_keys WHERE owner = $1 AND key = $2
+		`, record.Owner, record.Key).Scan(&existing.Owner, &existing.Key, &existing.RequestHash, &statusCode,
+			&contentType, &existing.Body, &existing.CreatedAt, &completedAt, &existing.ExpiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return IdempotencyRecord{}, false, err
+		}
+		existing.StatusCode = int(statusCode.Int64)
+		existing.ContentType = contentType.String
+		if completedAt.Valid {
+			existing.CompletedAt = &completedAt.Time
+		}
+		return existing, false, nil
+	}
+	return IdempotencyRecord{}, false, errors.New("idempotency key changed concurrently")
+}
+
+func (s *PostgresStore) CompleteIdempotencyKey(record IdempotencyRecord) error {
+	_, err := s.db.Exec(`
+		UPDATE idempotency_keys SET status_code = $3, content_type = $4, body = $5, completed_at = $6
+		WHERE owner = $1 AND key = $2
+	`, record.Owner, record.Key, record.StatusCode, record.ContentType, record.Body, record.CompletedAt)
+	return err
+}
+
+func (s *PostgresStore) ReleaseIdempotencyKey(owner, key string) error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE owner = $1 AND key = $2 AND completed_at IS NULL`, owner, key)
+	return err
+}
+
+func (s *PostgresStore) PurgeIdempotencyKeys(at time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at < $1`, at)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (s *PostgresStore) SaveGridIntensity(points []IntensityPoint) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, point := range points {
+		_, err := tx.Exec(`
+			INSERT INTO grid_intensity (region, hour, intensity, source)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (region, hour) DO UPDATE
+			SET intensity = EXCLUDED.intensity, source = EXCLUDED.source, updated_at = CURRENT_TIMESTAMP
+		`, point.Region, point.Hour.UTC(), point.Intensity, nullableString(point.Source))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) GridIntensity(region string, from, to time.Time) ([]IntensityPoint, error) {
+	rows, err := s.db.Query(`
+		SELECT region, hour, intensity, source
+		FROM grid_intensity
+		WHERE region = $1 AND hour >= $2 AND hour < $3
+		ORDER BY hour
+	`, region, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []IntensityPoint
+	for rows.Next() {
+		var point IntensityPoint
+		var source sql.NullString
+		if err := rows.Scan(&point.Region, &point.Hour, &point.Intensity, &source); err != nil {
+			return nil, err
+		}
+		point.Hour = point.Hour.UTC()
+		point.Source = source.String
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RatePlan sets the limits for a class of client. Zero means unlimited.
+type RatePlan struct {
+	RequestsPerMinute   int `json:"requests_per_minute"`
+	MonthlyCalculations int `json:"monthly_calculations"`
+}
+
+const (
+	defaultPlan   = "free"
+	unlimitedPlan = "unlimited"
+)
+
+// defaultRatePlans can be overridden or extended with RATE_LIMIT_PLANS, a
+// JSON object of plan name to RatePlan.
+var defaultRatePlans = map[string]RatePlan{
+	"free":        {RequestsPerMinute: 60, MonthlyCalculations: 1000},
+	"pro":         {RequestsPerMinute: 600, MonthlyCalculations: 100000},
+	"enterprise":  {RequestsPerMinute: 6000},
+	unlimitedPlan: {},
+}
+
+func ratePlansFromEnv() map[string]RatePlan {
+	plans := make(map[string]RatePlan, len(defaultRatePlans))
+	for name, plan := range defaultRatePlans {
+		plans[name] = plan
+	}
+	if raw := os.Getenv("RATE_LIMIT_PLANS"); raw != "" {
+		var overrides map[string]RatePlan
+		if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+			log.Fatalf("Invalid RATE_LIMIT_PLANS: %v", err)
+		}
+		for name, plan := range overrides {
+			plans[name] = plan
+		}
+	}
+	return plans
+}
+
+const rateLimitWindow = time.Minute
+
+// slidingWindowScript keeps a sorted set of request timestamps (ms) per
+// client. It returns {allowed, count, ms until the oldest request expires}.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+
+local reset = 0
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+// quotaScript adds cost to a counter unless that would exceed the limit. It
+// returns {allowed, used}.
+var quotaScript = redis.NewScript(`
+local used = redis.call('INCRBY', KEYS[1], ARGV[1])
+if used > tonumber(ARGV[2]) then
+	redis.call('DECRBY', KEYS[1], ARGV[1])
+	return {0, used - tonumber(ARGV[1])}
+end
+redis.call('EXPIREAT', KEYS[1], ARGV[3])
+return {1, used}
+`)
+
+// refundScript takes up to ARGV[1] back off an existing counter without
+// creating it or letting it go below zero, and returns the new count.
+var refundScript = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]))
+if not used then
+	return 0
+end
+local refunded = math.min(used, tonumber(ARGV[1]))
+return redis.call('DECRBY', KEYS[1], refunded)
+`)
+
+// RateLimiter enforces per-client request rates and monthly calculation
+// quotas in Redis, falling back to process-local counters when Redis is
+// unavailable. The fallback is per instance, so limits loosen while it is
+// in use behind a load balancer.
+type RateLimiter struct {
+	redis   *redis.Client
+	breaker redisBreaker
+	plans   map[string]RatePlan
+
+	mu      sync.Mutex
+	windows map[string][]time.Time
+	quotas  map[string]int
+}
+
+func NewRateLimiter(client *redis.Client, plans map[string]RatePlan) *RateLimiter {
+	return &RateLimiter{
+		redis:   client,
+		breaker: redisBreaker{name: "Rate limiter"},
+		plans:   plans,
+		windows: make(map[string][]time.Time),
+		quotas:  make(map[string]int),
+	}
+}
+
+// plan returns the limits for a caller, defaulting unknown plans to free.
+func (rl *RateLimiter) plan(principal Principal) RatePlan {
+	if plan, ok := rl.plans[principal.Plan]; ok {
+		return plan
+	}
+	return rl.plans[defaultPlan]
+}
+
+// clientKey identifies who is being limited: the API key, else the JWT
+// subject, else the remote address of an anonymous caller.
+func clientKey(c *fiber.Ctx, principal Principal) string {
+	switch {
+	case principal.APIKeyID != 0:
+		return "key:" + strconv.Itoa(principal.APIKeyID)
+	case principal.Subject != anonymousPrincipal.Subject:
+		return "user:" + principal.Subject
+	}
+	return "ip:" + c.IP()
+}
+
+func (rl *RateLimiter) useRedis() bool {
+	return rl.redis != nil && rl.breaker.available()
+}
+
+type windowResult struct {
+	allowed bool
+	count   int
+	reset   time.Duration
+}
+
+func (rl *RateLimiter) hit(ctx context.Context, client string, limit int) windowResult {
+	now := time.Now()
+	if rl.useRedis() {
+		values, err := slidingWindowScript.Run(ctx, rl.redis, []string{"ratelimit:" + client},
+			now.UnixMilli(), rateLimitWindow.Milliseconds(), limit, uuid.NewString()).Int64Slice()
+		if err == nil && len(values) == 3 {
+			return windowResult{
+				allowed: values[0] == 1,
+				count:   int(values[1]),
+				reset:   time.Duration(values[2]) * time.Millisecond,
+			}
+		}
+		rl.breaker.failed(err)
+	}
+	return rl.hitLocal(client, limit, now)
+}
+
+func (rl *RateLimiter) hitLocal(client string, limit int, now time.Time) windowResult {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cutoff := now.Add(-rateLimitWindow)
+	hits := rl.windows[client]
+	for len(hits) > 0 && !hits[0].After(cutoff) {
+		hits = hits[1:]
+	}
+
+	result := windowResult{count: len(hits)}
+	if len(hits) < limit {
+		hits = append(hits, now)
+		result.allowed = true
+		result.count++
+	}
+	result.reset = hits[0].Add(rateLimitWindow).Sub(now)
+	rl.windows[client] = hits
+
+	// Drop idle clients now and then so the map does not grow unbounded
+	if len(rl.windows) > 10000 {
+		for key, times := range rl.windows {
+			if len(times) == 0 || !times[len(times)-1].After(cutoff) {
+				delete(rl.windows, key)
+			}
+		}
+	}
+	return result
+}
+
+// Middleware applies the per-minute request limit of the caller's plan.
+func (rl *RateLimiter) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := principalFrom(c)
+		limit := rl.plan(principal).RequestsPerMinute
+		if limit <= 0 {
+			return c.Next()
+		}
+
+		result := rl.hit(c.UserContext(), clientKey(c, principal), limit)
+		resetSeconds := int(math.Ceil(result.reset.Seconds()))
+		c.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(max(limit-result.count, 0)))
+		c.Set("X-RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if !result.allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(resetSeconds, 1)))
+			return fiber.NewError(fiber.StatusTooManyRequests,
+				fmt.Sprintf("Rate limit of %d requests per minute exceeded", limit))
+		}
+		return c.Next()
+	}
+}
+
+// nextMonth returns the start of the month after t, in UTC.
+func nextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// quotaKey names the client's calculation counter for the month of t.
+func quotaKey(client string, t time.Time) string {
+	return "quota:" + client + ":" + t.UTC().Format("2006-01")
+}
+
+// consume adds cost to the counter at key, reporting whether it stayed
+// within limit, the resulting count and whether Redis held the counter.
+func (rl *RateLimiter) consume(ctx context.Context, key string, cost, limit int, now time.Time) (ok bool, used int, inRedis bool) {
+	if rl.useRedis() {
+		// Keep the counter a day past month end so late requests still see it
+		expireAt := nextMonth(now).Add(24 * time.Hour).Unix()
+		values, err := quotaScript.Run(ctx, rl.redis, []string{key}, cost, limit, expireAt).Int64Slice()
+		if err == nil && len(values) == 2 {
+			return values[0] == 1, int(values[1]), true
+		}
+		rl.breaker.failed(err)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	used = rl.quotas[key]
+	if used+cost > limit {
+		return false, used, false
+	}
+	rl.quotas[key] = used + cost
+	return true, used + cost, false
+}
+
+// refund returns quota consumed by a request that did not succeed to the
+// counter it was taken from, never taking that counter below zero.
+func (rl *RateLimiter) refund(ctx context.Context, key string, cost int, inRedis bool) {
+	if inRedis {
+		if err := refundScript.Run(ctx, rl.redis, []string{key}, cost).Err(); err != nil {
+			log.Printf("Failed to refund %d calculations to %s: %v", cost, key, err)
+		}
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if used, ok := rl.quotas[key]; ok {
+		rl.quotas[key] = max(used-cost, 0)
+	}
+}
+
+// charge takes n calculations from the caller's monthly quota and sets the
+// quota headers. The returned refund gives back some or all of them to the
+// same month's counter, in Redis or in process as the charge was.
+func (rl *RateLimiter) charge(c *fiber.Ctx, n int) (refund func(n int), err error) {
+	principal := principalFrom(c)
+	limit := rl.plan(principal).MonthlyCalculations
+	if limit <= 0 {
+		return func(int) {}, nil
+	}
+
+	now := time.Now()
+	key := quotaKey(clientKey(c, principal), now)
+	ok, used, inRedis := rl.consume(c.UserContext(), key, n, limit, now)
+	reset := nextMonth(now)
+	c.Set("X-Quota-Limit", strconv.Itoa(limit))
+	c.Set("X-Quota-Remaining", strconv.Itoa(max(limit-used, 0)))
+	c.Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+	if !ok {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds()))))
+		return nil, fiber.NewError(fiber.StatusTooManyRequests,
+			fmt.Sprintf("Monthly quota of %d calculations exhausted", limit))
+	}
+	return func(n int) { rl.refund(context.Background(), key, n, inRedis) }, nil
+}
+
+// Quota charges calculations against the caller's monthly quota. cost
+// returns how many calculations the request performs; requests that fail
+// are refunded.
+func (rl *RateLimiter) Quota(cost func(c *fiber.Ctx) int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		n := cost(c)
+		refund, err := rl.charge(c, n)
+		if err != nil {
+			return err
+		}
+
+		err = c.Next()
+		if err != nil || c.Response().StatusCode() >= 400 {
+			refund(n)
+		}
+		return err
+	}
+}
+
+// perRequest charges one calculation per request.
+func perRequest(c *fiber.Ctx) int { return 1 }
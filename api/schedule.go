@@ -0,0 +1,184 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxScheduleSpan bounds how far ahead a job can be scheduled.
+	maxScheduleSpan = 7 * 24 * time.Hour
+	// scheduleStep is the spacing of the candidate start times.
+	scheduleStep = 15 * time.Minute
+)
+
+// ScheduleRequest describes a flexible job: it uses EnergyKWh evenly over
+// DurationMinutes and must start no earlier than EarliestStart (default
+// now) and finish by Deadline.
+type ScheduleRequest struct {
+	EnergyKWh       float64    `json:"energy_kwh"`
+	DurationMinutes int        `json:"duration_minutes"`
+	EarliestStart   *time.Time `json:"earliest_start,omitempty"`
+	Deadline        time.Time  `json:"deadline"`
+	Region          string     `json:"region,omitempty"`
+	Country         string     `json:"country,omitempty"`
+}
+
+// ScheduleWindow is one possible run of the job.
+type ScheduleWindow struct {
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	CarbonFootprint  float64   `json:"carbon_footprint"`
+	AverageIntensity float64   `json:"average_intensity"`
+}
+
+type ScheduleResponse struct {
+	RequestedRegion string `json:"requested_region"`
+	Region          string `json:"region"`
+	RegionLevel     string `json:"region_level"`
+	Unit            string `json:"unit"`
+	// Recommended is the window with the lowest footprint, the earliest of
+	// equals. Savings compare it with AtEarliestStart, running the job as
+	// soon as it may start, which is now unless earliest_start was given.
+	Recommended     ScheduleWindow `json:"recommended"`
+	AtEarliestStart ScheduleWindow `json:"at_earliest_start"`
+	Savings         float64        `json:"savings"`
+	SavingsPercent  float64        `json:"savings_percent"`
+	// Hours of the search range without hourly data use FallbackIntensity,
+	// the region's annual grid factor.
+	HoursWithIntensity int     `json:"hours_with_intensity"`
+	HoursEstimated     int     `json:"hours_estimated"`
+	FallbackIntensity  float64 `json:"fallback_intensity"`
+}
+
+// Schedule handles POST /schedule, recommending when to run a job so that
+// it emits the least, from the region's hourly grid intensity.
+func (cs *CarbonService) Schedule(c *fiber.Ctx) error {
+	var req ScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   true,
+			"message": "Invalid request format",
+		})
+	}
+
+	earliest := time.Now().UTC().Truncate(time.Minute)
+	if req.EarliestStart != nil {
+		earliest = req.EarliestStart.UTC()
+	}
+	duration := time.Duration(req.DurationMinutes) * time.Minute
+	region := combineRegion(req.Region, req.Country)
+	if err := validateSchedule(req, earliest, duration, region); err != nil {
+		return validationResponse(c, err)
+	}
+
+	fallback, err := cs.getEmissionFactor(FactorQuery{Activity: "electricity", Mode: "grid", Region: region})
+	if err != nil {
+		log.Printf("Failed to load grid factor for scheduling: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to load grid intensity")
+	}
+	series, err := cs.HourlyIntensity(region, earliest, req.Deadline)
+	if err != nil {
+		log.Printf("Failed to load grid intensity for scheduling: %v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to load grid intensity")
+	}
+
+	hourly := make(map[time.Time]float64, len(series.Points))
+	for _, point := range series.Points {
+		hourly[point.Hour] = point.Intensity
+	}
+	intensity := func(hour time.Time) float64 {
+		if rate, ok := hourly[hour]; ok {
+			return rate
+		}
+		return fallback.Factor
+	}
+
+	first := scheduleWindow(earliest, duration, req.EnergyKWh, intensity)
+	best := first
+	for start := earliest.Truncate(scheduleStep).Add(scheduleStep); !start.Add(duration).After(req.Deadline); start = start.Add(scheduleStep) {
+		// Ignore differences below a gram, so rounding noise does not push
+		// the job later
+		if window := scheduleWindow(start, duration, req.EnergyKWh, intensity); window.CarbonFootprint < best.CarbonFootprint-0.001 {
+			best = window
+		}
+	}
+
+	response := ScheduleResponse{
+		RequestedRegion:   firstNonEmpty(region, worldRegion),
+		Region:            firstNonEmpty(series.Region, fallback.Region),
+		Unit:              "kg_co2e",
+		Recommended:       roundScheduleWindow(best),
+		AtEarliestStart:   roundScheduleWindow(first),
+		Savings:           roundTo(first.CarbonFootprint-best.CarbonFootprint, 3),
+		FallbackIntensity: fallback.Factor,
+	}
+	response.RegionLevel = regionLevel(response.Region)
+	if first.CarbonFootprint > 0 {
+		response.SavingsPercent = roundTo((first.CarbonFootprint-best.CarbonFootprint)/first.CarbonFootprint*100, 1)
+	}
+	for hour := earliest.Truncate(time.Hour); hour.Before(req.Deadline); hour = hour.Add(time.Hour) {
+		if _, ok := hourly[hour]; ok {
+			response.HoursWithIntensity++
+		} else {
+			response.HoursEstimated++
+		}
+	}
+
+	return c.JSON(response)
+}
+
+func validateSchedule(req ScheduleRequest, earliest time.Time, duration time.Duration, region string) error {
+	if err := requirePositive("energy_kwh", req.EnergyKWh); err != nil {
+		return err
+	}
+	if req.DurationMinutes <= 0 {
+		return invalidField("duration_minutes", "must be greater than zero")
+	}
+	if req.Deadline.IsZero() {
+		return invalidField("deadline", "is required")
+	}
+	if earliest.Add(duration).After(req.Deadline) {
+		return invalidField("deadline", "leaves no time to run the job after earliest_start")
+	}
+	if req.Deadline.Sub(earliest) > maxScheduleSpan {
+		return invalidField("deadline", "must be within 7 days of earliest_start")
+	}
+	if len(region) > 50 {
+		return invalidField("region", "must be at most 50 characters")
+	}
+	return nil
+}
+
+// scheduleWindow spreads the energy evenly over the run and prices each
+// part at the intensity of its hour.
+func scheduleWindow(start time.Time, duration time.Duration, kwh float64, intensity func(time.Time) float64) ScheduleWindow {
+	end := start.Add(duration)
+	perHour := kwh / duration.Hours()
+
+	var footprint float64
+	for t := start; t.Before(end); {
+		hour := t.Truncate(time.Hour)
+		next := hour.Add(time.Hour)
+		if next.After(end) {
+			next = end
+		}
+		footprint += perHour * next.Sub(t).Hours() * intensity(hour)
+		t = next
+	}
+
+	return ScheduleWindow{
+		Start:            start,
+		End:              end,
+		CarbonFootprint:  footprint,
+		AverageIntensity: footprint / kwh,
+	}
+}
+
+func roundScheduleWindow(window ScheduleWindow) ScheduleWindow {
+	window.CarbonFootprint = roundTo(window.CarbonFootprint, 3)
+	window.AverageIntensity = roundTo(window.AverageIntensity, 6)
+	return window
+}
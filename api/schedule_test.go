@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScheduleWindow(t *testing.T) {
+	midnight := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	hourly := map[time.Time]float64{
+		midnight:                    0.5,
+		midnight.Add(time.Hour):     0.1,
+		midnight.Add(2 * time.Hour): 0.3,
+	}
+	intensity := func(hour time.Time) float64 { return hourly[hour] }
+
+	tests := []struct {
+		name      string
+		start     time.Duration
+		duration  time.Duration
+		kwh       float64
+		footprint float64
+		average   float64
+	}{
+		{"whole hours", 0, 2 * time.Hour, 10, 3, 0.3},
+		{"straddling an hour", 30 * time.Minute, time.Hour, 4, 1.2, 0.3},
+		{"within an hour", 15 * time.Minute, 30 * time.Minute, 1, 0.5, 0.5},
+		{"partial hours at both ends", 45 * time.Minute, 90 * time.Minute, 6, 1.2, 0.2},
+	}
+	for _, tt := range tests {
+		window := scheduleWindow(midnight.Add(tt.start), tt.duration, tt.kwh, intensity)
+		if !window.End.Equal(midnight.Add(tt.start + tt.duration)) {
+			t.Errorf("%s: end = %v", tt.name, window.End)
+		}
+		if math.Abs(window.CarbonFootprint-tt.footprint) > 1e-9 {
+			t.Errorf("%s: footprint = %v, want %v", tt.name, window.CarbonFootprint, tt.footprint)
+		}
+		if math.Abs(window.AverageIntensity-tt.average) > 1e-9 {
+			t.Errorf("%s: average intensity = %v, want %v", tt.name, window.AverageIntensity, tt.average)
+		}
+	}
+}
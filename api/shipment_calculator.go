@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func init() {
+	RegisterCalculator(shipmentCalculator{})
+}
+
+// ShipmentLeg is one transport operation in a multi-leg shipment.
+type ShipmentLeg struct {
+	Mode       string  `json:"mode"`
+	From       string  `json:"from,omitempty"`
+	To         string  `json:"to,omitempty"`
+	Distance   float64 `json:"distance,omitempty"`
+	Weight     float64 `json:"weight,omitempty"`      // kg, defaults to the shipment weight
+	LoadFactor float64 `json:"load_factor,omitempty"` // 0-1, defaults to the mode average
+}
+
+// ShipmentHub is a warehouse or terminal the freight passes through.
+type ShipmentHub struct {
+	Type     string  `json:"type"`
+	Location string  `json:"location,omitempty"`
+	Weight   float64 `json:"weight,omitempty"` // kg, defaults to the shipment weight
+}
+
+// defaultLoadFactors are the average utilisation the shipping factors assume.
+var defaultLoadFactors = map[string]float64{
+	"air":  0.7,
+	"sea":  0.7,
+	"road": 0.6,
+	"rail": 0.6,
+}
+
+// shipmentCalculator follows the GLEC Framework transport chain: an ordered
+// list of legs (transport operations) plus the hubs between them.
+type shipmentCalculator struct{}
+
+func (shipmentCalculator) Activity() string { return "shipment" }
+
+func (shipmentCalculator) Describe() ActivityDescription {
+	return ActivityDescription{
+		Description: "Calculate carbon footprint for intermodal freight journeys",
+		OptionsKey:  "hub_types",
+		Options:     defaultModes("hub"),
+		Example: map[string]interface{}{
+			"activity": "shipment",
+			"weight":   12000,
+			"legs": []map[string]interface{}{
+				{"mode": "road", "from": "Shenzhen", "to": "CNSZX"},
+				{"mode": "sea", "from": "CNSZX", "to": "NLRTM", "load_factor": 0.8},
+				{"mode": "rail", "from": "NLRTM", "to": "Munich"},
+			},
+			"hubs": []map[string]interface{}{
+				{"type": "container_terminal", "location": "NLRTM"},
+			},
+		},
+	}
+}
+
+func (shipmentCalculator) RequiredFields() []string {
+	return []string{"activity", "weight_or_leg_weights", "legs"}
+}
+
+func (shipmentCalculator) Formula() string {
+	return "Σ legs(weight_tonnes × distance_km × emission_factor × load_adjustment) + Σ hubs(weight_tonnes × hub_factor)"
+}
+
+func (shipmentCalculator) Validate(req CalculateRequest) error {
+	if len(req.Legs) == 0 {
+		return invalidField("legs", "at least one leg is required")
+	}
+	if req.Weight < 0 {
+		return invalidField("weight", "must not be negative")
+	}
+	for i, leg := range req.Legs {
+		field := fmt.Sprintf("legs[%d]", i)
+		if leg.Mode == "" {
+			return invalidField(field+".mode", "is required")
+		}
+		if leg.Weight < 0 || (leg.Weight == 0 && req.Weight == 0) {
+			return invalidField(field+".weight", "a leg weight or shipment weight is required")
+		}
+		if leg.LoadFactor < 0 || leg.LoadFactor > 1 {
+			return invalidField(field+".load_factor", "must be between 0 and 1")
+		}
+		if _, _, err := legDistance(leg.Distance, leg.From, leg.To, leg.Mode); err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				verr.Field = field + "." + verr.Field
+			}
+			return err
+		}
+	}
+	for i, hub := range req.Hubs {
+		field := fmt.Sprintf("hubs[%d]", i)
+		if hub.Type == "" {
+			return invalidField(field+".type", "is required")
+		}
+		if hub.Weight < 0 || (hub.Weight == 0 && req.Weight == 0) {
+			return invalidField(field+".weight", "a hub weight or shipment weight is required")
+		}
+	}
+	return nil
+}
+
+func (shipmentCalculator) Calculate(factors FactorSource, req CalculateRequest) (CalculatorResult, error) {
+	var transportTotal, hubTotal, tonneKm, distanceTotal float64
+	region := requestRegion(req)
+	gases := &GasAmounts{}
+
+	legs := make([]map[string]interface{}, 0, len(req.Legs))
+	for i, leg := range req.Legs {
+		factor, err := factors.EmissionFactor(FactorQuery{Activity: "shipping", Mode: leg.Mode, Region: region})
+		if err != nil {
+			return CalculatorResult{}, renameField(err, fmt.Sprintf("legs[%d].mode", i))
+		}
+		distance, route, err := legDistance(leg.Distance, leg.From, leg.To, leg.Mode)
+		if err != nil {
+			return CalculatorResult{}, err
+		}
+		distance = sampleEstimate(factors, fmt.Sprintf("legs[%d].distance", i), distance, route.uncertainty())
+
+		weightKg := leg.Weight
+		if weightKg == 0 {
+			weightKg = req.Weight
+		}
+		weightTonnes := weightKg / 1000.0
+
+		// Factors assume average utilisation; scale for the actual load.
+		loadAdjustment := 1.0
+		if leg.LoadFactor > 0 {
+			if average, exists := defaultLoadFactors[leg.Mode]; exists {
+				loadAdjustment = average / leg.LoadFactor
+			}
+		}
+
+		emissions := weightTonnes * distance * factor.Factor * loadAdjustment
+		transportTotal += emissions
+		gases = gases.plus(gasAmounts(factor, weightTonnes*distance*loadAdjustment))
+		tonneKm += weightTonnes * distance
+		distanceTotal += distance
+
+		entry := map[string]interface{}{
+			"leg":             i + 1,
+			"mode":            leg.Mode,
+			"from":            leg.From,
+			"to":              leg.To,
+			"distance_km":     roundTo(distance, 1),
+			"weight_tonnes":   weightTonnes,
+			"tonne_km":        roundTo(weightTonnes*distance, 3),
+			"emission_factor": factor.Factor,
+			"load_adjustment": roundTo(loadAdjustment, 4),
+			"kg_co2e":         roundTo(emissions, 3),
+		}
+		if route != nil {
+			entry["distance_source"] = "estimated"
+			entry["distance_confidence"] = route.Confidence
+		} else {
+			entry["distance_source"] = "provided"
+		}
+		legs = append(legs, entry)
+	}
+
+	hubs := make([]map[string]interface{}, 0, len(req.Hubs))
+	for i, hub := range req.Hubs {
+		factor, err := factors.EmissionFactor(FactorQuery{Activity: "hub", Mode: hub.Type, Region: region})
+		if err != nil {
+			return CalculatorResult{}, renameField(err, fmt.Sprintf("hubs[%d].type", i))
+		}
+
+		weightKg := hub.Weight
+		if weightKg == 0 {
+			weightKg = req.Weight
+		}
+		weightTonnes := weightKg / 1000.0
+		emissions := weightTonnes * factor.Factor
+		hubTotal += emissions
+		gases = gases.plus(gasAmounts(factor, weightTonnes))
+
+		hubs = append(hubs, map[string]interface{}{
+			"type":            hub.Type,
+			"location":        hub.Location,
+			"weight_tonnes":   weightTonnes,
+			"emission_factor": factor.Factor,
+			"kg_co2e":         roundTo(emissions, 3),
+		})
+	}
+
+	total := transportTotal + hubTotal
+	breakdown := map[string]interface{}{
+		"framework":         "GLEC",
+		"legs":              legs,
+		"hubs":              hubs,
+		"transport_kg_co2e": roundTo(transportTotal, 3),
+		"hub_kg_co2e":       roundTo(hubTotal, 3),
+		"total_tonne_km":    roundTo(tonneKm, 3),
+		"total_distance_km": roundTo(distanceTotal, 1),
+	}
+
+	return CalculatorResult{CarbonFootprint: total, Breakdown: breakdown, Gases: gases}, nil
+}
+
+func (shipmentCalculator) Suggestions(req CalculateRequest, carbonFootprint float64) []string {
+	var suggestions []string
+	for _, leg := range req.Legs {
+		if leg.Mode == "air" {
+			suggestions = append(suggestions, "Replace air legs with sea or rail to cut their emissions by over 95%")
+			break
+		}
+	}
+	for _, leg := range req.Legs {
+		if leg.LoadFactor > 0 && leg.LoadFactor < 0.5 {
+			suggestions = append(suggestions, "Consolidate freight to raise load factors on under-utilised legs")
+			break
+		}
+	}
+	return suggestions
+}
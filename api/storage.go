@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a stored record does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicateFactor is returned when a factor with the same activity,
+	// transport mode, region and version already exists.
+	ErrDuplicateFactor = errors.New("duplicate emission factor")
+)
+
+// Storage is the persistence layer behind CarbonService. PostgresStore is
+// used in production; MemoryStore keeps the API working without a database.
+type Storage interface {
+	// FindEmissionFactor returns the factor valid at q.At, preferring the most
+	// recently effective version. q.Region and q.At must be set.
+	FindEmissionFactor(q FactorQuery) (EmissionFactor, error)
+	// ListEmissionFactors returns all factors, or only those valid at at when
+	// it is non-zero.
+	ListEmissionFactors(at time.Time) ([]EmissionFactor, error)
+	CreateEmissionFactor(factor EmissionFactor) (int, error)
+	UpdateEmissionFactor(id int, factor EmissionFactor) error
+	// RetireEmissionFactor closes a factor's validity at the given date.
+	RetireEmissionFactor(id int, at time.Time) (EmissionFactor, error)
+	// ImportEmissionFactors writes already validated factors atomically.
+	// Duplicates are skipped or reported as row errors, in which case
+	// nothing is written.
+	ImportEmissionFactors(factors []EmissionFactor, skipDuplicates bool) (*FactorImportResult, error)
+
+	CreateAPIKey(key APIKey) (APIKey, error)
+	ListAPIKeys() ([]APIKey, error)
+	// FindAPIKey looks a key up by its public prefix, including revoked keys.
+	FindAPIKey(prefix string) (APIKey, error)
+	// RevokeAPIKey marks an active key revoked and returns it.
+	RevokeAPIKey(id int, at time.Time) (APIKey, error)
+
+	// SaveCalculation stores a calculation. Saving a CalculationID that is
+	// already stored returns the existing record's ID.
+	SaveCalculation(record CalculationRecord) (int, error)
+	// SaveCalculations stores a batch in one transaction, returning IDs in
+	// the same order.
+	SaveCalculations(records []CalculationRecord) ([]int, error)
+	GetCalculation(id int) (CalculationRecord, error)
+	// FindCalculation looks a calculation up by its CalculationID.
+	FindCalculation(calculationID string) (CalculationRecord, error)
+	// ListCalculations returns up to filter.Limit matching calculations in
+	// filter.Sort order, starting after filter.After when it is set.
+	ListCalculations(filter CalculationFilter) ([]CalculationRecord, error)
+	// RecordAPIUsage logs a request and updates the last-used time of the
+	// caller's API key, if any.
+	RecordAPIUsage(endpoint string, principal Principal, responseTime time.Duration) error
+	Analytics() (*UsageAnalytics, error)
+
+	// ReserveIdempotencyKey claims record's owner and key for a request in
+	// progress. If the key is already held, the holder is returned with
+	// reserved false. Expired records, and reservations older than
+	// staleBefore that never completed, are replaced.
+	ReserveIdempotencyKey(record IdempotencyRecord, staleBefore time.Time) (existing IdempotencyRecord, reserved bool, err error)
+	// CompleteIdempotencyKey stores the response of a reserved key.
+	CompleteIdempotencyKey(record IdempotencyRecord) error
+	// ReleaseIdempotencyKey drops a reservation so the request can be retried.
+	ReleaseIdempotencyKey(owner, key string) error
+	// PurgeIdempotencyKeys deletes records that expired before at.
+	PurgeIdempotencyKeys(at time.Time) (int, error)
+
+	// SaveGridIntensity writes hourly intensities, replacing stored values
+	// for the same region and hour.
+	SaveGridIntensity(points []IntensityPoint) error
+	// GridIntensity returns a region's hourly intensities in [from, to),
+	// ordered by hour.
+	GridIntensity(region string, from, to time.Time) ([]IntensityPoint, error)
+
+	Close() error
+}
+
+// CalculationRecord is a stored calculation.
+type CalculationRecord struct {
+	ID              int               `json:"id"`
+	CalculationID   string            `json:"calculation_id,omitempty"`
+	Activity        string            `json:"activity"`
+	Input           CalculateRequest  `json:"input"`
+	CarbonFootprint float64           `json:"carbon_footprint"`
+	Unit            string            `json:"unit"`
+	UserID          string            `json:"user_id"`
+	OrgID           string            `json:"org_id,omitempty"`
+	Factors         []FactorReference `json:"factors"`
+	ActivityDate    *Date             `json:"activity_date,omitempty"`
+	Tags            []string          `json:"tags"`
+	Scope           int               `json:"scope,omitempty"`
+	Scope3Category  int               `json:"scope3_category,omitempty"`
+	// Result is the response returned to the client. It is missing for
+	// calculations stored before results were kept.
+	Result    *CalculateResponse `json:"result,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// Sort orders for listing calculations.
+const (
+	SortCreatedAt       = "created_at"
+	SortCarbonFootprint = "carbon_footprint"
+)
+
+// CalculationFilter selects stored calculations. Zero values match
+// everything.
+type CalculationFilter struct {
+	Activity     string
+	UserID       string
+	OrgID        string
+	Tag          string
+	Scope        int
+	From         time.Time // created at or after
+	To           time.Time // created before
+	MinFootprint *float64
+	MaxFootprint *float64
+
+	Sort       string
+	Descending bool
+	After      *CalculationCursor
+	Limit      int
+}
+
+// CalculationCursor is the position of the last record of a page: its sort
+// value and ID.
+type CalculationCursor struct {
+	Sort            string    `json:"s"`
+	CreatedAt       time.Time `json:"c"`
+	CarbonFootprint float64   `json:"f,omitempty"`
+	ID              int       `json:"i"`
+}
+
+// IdempotencyRecord is a request made with an Idempotency-Key and, once it
+// has completed, the response to replay.
+type IdempotencyRecord struct {
+	Owner       string
+	Key         string
+	RequestHash []byte
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	ExpiresAt   time.Time
+}
+
+// IntensityPoint is the average carbon intensity of a region's grid during
+// one hour, in kg CO2e per kWh.
+type IntensityPoint struct {
+	Region    string    `json:"region"`
+	Hour      time.Time `json:"hour"`
+	Intensity float64   `json:"intensity"`
+	Source    string    `json:"source,omitempty"`
+}
+
+// UsageAnalytics summarises stored calculations and API usage.
+type UsageAnalytics struct {
+	TotalCalculations     int
+	AvgResponseTimeMs     float64
+	TotalCarbonCalculated float64
+	TopActivities         map[string]int
+	// EmissionsByScope totals footprints by scopeKey.
+	EmissionsByScope map[string]float64
+	// Scope3ByCategory totals Scope 3 footprints by category, 0 for
+	// uncategorized.
+	Scope3ByCategory map[int]float64
+}
+
+const duplicateFactorMessage = "an emission factor with the same activity, transport_mode, region and version already exists"
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Distributions an Uncertainty can take.
+const (
+	DistributionNormal     = "normal"
+	DistributionLognormal  = "lognormal"
+	DistributionUniform    = "uniform"
+	DistributionTriangular = "triangular"
+)
+
+const (
+	// monteCarloSamples is how many times a calculation is repeated with
+	// sampled inputs.
+	monteCarloSamples = 1000
+	// monteCarloSeed seeds every propagation alike, so the same request
+	// always reports the same range.
+	monteCarloSeed = 1
+)
+
+// Uncertainty describes how far an emission factor or an estimated input
+// may be from its central value. The parameters are relative to that value,
+// so one range fits factors of any size.
+type Uncertainty struct {
+	Distribution string `json:"distribution"`
+	// StdDev is the relative standard deviation of a normal distribution,
+	// 0.1 for ±10%.
+	StdDev float64 `json:"std_dev,omitempty"`
+	// GSD is the geometric standard deviation of a lognormal distribution,
+	// whose median is the central value.
+	GSD float64 `json:"gsd,omitempty"`
+	// Low and High bound uniform and triangular distributions as multiples
+	// of the central value; triangular ones peak at it.
+	Low  float64 `json:"low,omitempty"`
+	High float64 `json:"high,omitempty"`
+}
+
+func (u Uncertainty) validate(field string) error {
+	switch u.Distribution {
+	case DistributionNormal:
+		if u.StdDev <= 0 || u.StdDev > 1 {
+			return invalidField(field+".std_dev", "must be greater than 0 and at most 1")
+		}
+	case DistributionLognormal:
+		if u.GSD <= 1 || u.GSD > 10 {
+			return invalidField(field+".gsd", "must be greater than 1 and at most 10")
+		}
+	case DistributionUniform, DistributionTriangular:
+		if u.Low < 0 || u.Low > 1 || u.High < 1 || u.High <= u.Low {
+			return invalidField(field, "low and high must bracket 1, with 0 <= low < high")
+		}
+	default:
+		return invalidField(field+".distribution", "must be one of %s, %s, %s, %s",
+			DistributionLognormal, DistributionNormal, DistributionTriangular, DistributionUniform)
+	}
+	return nil
+}
+
+// sample draws a multiple of the central value. Normal draws are cut at
+// zero since neither factors nor distances can be negative.
+func (u Uncertainty) sample(rng *rand.Rand) float64 {
+	switch u.Distribution {
+	case DistributionNormal:
+		return math.Max(0, 1+rng.NormFloat64()*u.StdDev)
+	case DistributionLognormal:
+		return math.Exp(rng.NormFloat64() * math.Log(u.GSD))
+	case DistributionUniform:
+		return u.Low + rng.Float64()*(u.High-u.Low)
+	case DistributionTriangular:
+		// Inverse of the CDF of a triangle peaking at 1
+		p, width := rng.Float64(), u.High-u.Low
+		if p < (1-u.Low)/width {
+			return u.Low + math.Sqrt(p*width*(1-u.Low))
+		}
+		return u.High - math.Sqrt((1-p)*width*(u.High-1))
+	}
+	return 1
+}
+
+// defaultFactorUncertainty applies to factors published without a range.
+// Fuel combustion factors are well known; grid averages, aviation and
+// freight much less so.
+var defaultFactorUncertainty = map[string]Uncertainty{
+	"fuel":        {Distribution: DistributionNormal, StdDev: 0.03},
+	"electricity": {Distribution: DistributionNormal, StdDev: 0.1},
+	"flight":      {Distribution: DistributionLognormal, GSD: 1.2},
+	"shipping":    {Distribution: DistributionLognormal, GSD: 1.3},
+	"hub":         {Distribution: DistributionLognormal, GSD: 1.5},
+}
+
+// unknownFactorUncertainty covers other activities and the placeholder
+// factor used when nothing matched.
+var unknownFactorUncertainty = Uncertainty{Distribution: DistributionLognormal, GSD: 3}
+
+// factorUncertainty returns a factor's range and whether it came from the
+// factor or a default.
+func factorUncertainty(factor EmissionFactor) (Uncertainty, string) {
+	if factor.Uncertainty != nil {
+		return *factor.Uncertainty, "factor"
+	}
+	if u, ok := defaultFactorUncertainty[factor.Activity]; ok && factor.Source != "Default" {
+		return u, "default"
+	}
+	return unknownFactorUncertainty, "default"
+}
+
+// UncertainInput is an input a calculation was sampled over.
+type UncertainInput struct {
+	Name string `json:"name"`
+	Uncertainty
+	// Source is "factor" for a published range, "default" for an assumed
+	// one and "estimate" for inputs the calculator derived.
+	Source string `json:"source"`
+}
+
+// DataQuality scores a result from 1 (very good) to 5 (very poor) by the
+// width of its 90% interval relative to the mean. Results resting on an
+// assumed range score 2 at best.
+type DataQuality struct {
+	Score         int     `json:"score"`
+	Rating        string  `json:"rating"`
+	RelativeRange float64 `json:"relative_range"`
+}
+
+// dataQualityLevels are the upper bounds of the half-width of the 90%
+// interval, as a fraction of the mean, for each score.
+var dataQualityLevels = []struct {
+	maxRange float64
+	rating   string
+}{
+	{0.05, "very_good"},
+	{0.15, "good"},
+	{0.3, "fair"},
+	{0.6, "poor"},
+	{math.Inf(1), "very_poor"},
+}
+
+// UncertaintyResult summarises the footprints of a Monte Carlo run.
+type UncertaintyResult struct {
+	Method      string           `json:"method"`
+	Samples     int              `json:"samples"`
+	Mean        float64          `json:"mean"`
+	StdDev      float64          `json:"std_dev"`
+	P5          float64          `json:"p5"`
+	P95         float64          `json:"p95"`
+	DataQuality DataQuality      `json:"data_quality"`
+	Inputs      []UncertainInput `json:"inputs"`
+}
+
+// propagateUncertainty repeats a calculation, drawing every emission factor
+// and estimated input from its range, and summarises the footprints. An
+// input used twice in one run, such as a factor shared by two legs, gets
+// the same draw both times.
+func propagateUncertainty(calc Calculator, factors FactorSource, req CalculateRequest) (*UncertaintyResult, error) {
+	sampler := &monteCarloSource{
+		source:    factors,
+		rng:       rand.New(rand.NewSource(monteCarloSeed)),
+		factors:   make(map[FactorQuery]factorLookup),
+		intensity: make(map[intensityQuery]IntensitySeries),
+		inputs:    make(map[string]UncertainInput),
+	}
+
+	footprints := make([]float64, monteCarloSamples)
+	for i := range footprints {
+		sampler.draws = make(map[string]float64)
+		result, err := calc.Calculate(sampler, req)
+		if err != nil {
+			return nil, err
+		}
+		footprints[i] = result.CarbonFootprint
+	}
+	return summarizeSamples(footprints, sampler.inputs), nil
+}
+
+func summarizeSamples(footprints []float64, inputs map[string]UncertainInput) *UncertaintyResult {
+	sort.Float64s(footprints)
+
+	var sum, squares float64
+	for _, footprint := range footprints {
+		sum += footprint
+	}
+	mean := sum / float64(len(footprints))
+	for _, footprint := range footprints {
+		squares += (footprint - mean) * (footprint - mean)
+	}
+	percentile := func(p float64) float64 {
+		return footprints[int(math.Ceil(p*float64(len(footprints))))-1]
+	}
+
+	result := &UncertaintyResult{
+		Method:  "monte_carlo",
+		Samples: len(footprints),
+		Mean:    roundTo(mean, 3),
+		StdDev:  roundTo(math.Sqrt(squares/float64(len(footprints)-1)), 3),
+		P5:      roundTo(percentile(0.05), 3),
+		P95:     roundTo(percentile(0.95), 3),
+		Inputs:  make([]UncertainInput, 0, len(inputs)),
+	}
+
+	assumed := false
+	for _, input := range inputs {
+		result.Inputs = append(result.Inputs, input)
+		assumed = assumed || input.Source == "default"
+	}
+	sort.Slice(result.Inputs, func(i, j int) bool { return result.Inputs[i].Name < result.Inputs[j].Name })
+
+	var relativeRange float64
+	if mean > 0 {
+		relativeRange = (percentile(0.95) - percentile(0.05)) / 2 / mean
+	}
+	score := 1
+	for score < len(dataQualityLevels) && relativeRange > dataQualityLevels[score-1].maxRange {
+		score++
+	}
+	if assumed && score < 2 {
+		score = 2
+	}
+	result.DataQuality = DataQuality{
+		Score:         score,
+		Rating:        dataQualityLevels[score-1].rating,
+		RelativeRange: roundTo(relativeRange, 4),
+	}
+	return result
+}
+
+type factorLookup struct {
+	factor EmissionFactor
+	err    error
+}
+
+type intensityQuery struct {
+	region   string
+	from, to int64
+}
+
+// monteCarloSource hands calculators sampled factors and estimates. The
+// underlying lookups are the same in every run, so they are made once.
+type monteCarloSource struct {
+	source    FactorSource
+	rng       *rand.Rand
+	factors   map[FactorQuery]factorLookup
+	intensity map[intensityQuery]IntensitySeries
+	// draws holds the multiples drawn for the current run, by input name
+	draws  map[string]float64
+	inputs map[string]UncertainInput
+}
+
+func (m *monteCarloSource) EmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	lookup, ok := m.factors[q]
+	if !ok {
+		lookup.factor, lookup.err = m.source.EmissionFactor(q)
+		m.factors[q] = lookup
+	}
+	if lookup.err != nil {
+		return lookup.factor, lookup.err
+	}
+
+	factor := lookup.factor
+	u, source := factorUncertainty(factor)
+	factor.Factor *= m.draw("factor:"+factor.Activity+"/"+factor.TransportMode+"/"+factor.Region, u, source)
+	return factor, nil
+}
+
+// HourlyIntensity scales the whole series by one draw: hourly figures of a
+// grid share the errors of the model behind them.
+func (m *monteCarloSource) HourlyIntensity(region string, from, to time.Time) (IntensitySeries, error) {
+	key := intensityQuery{region: region, from: from.Unix(), to: to.Unix()}
+	series, ok := m.intensity[key]
+	if !ok {
+		if source, isIntensity := m.source.(IntensitySource); isIntensity {
+			var err error
+			if series, err = source.HourlyIntensity(region, from, to); err != nil {
+				return series, err
+			}
+		}
+		m.intensity[key] = series
+	}
+	if len(series.Points) == 0 {
+		return series, nil
+	}
+
+	scale := m.draw("hourly_intensity:"+series.Region, defaultFactorUncertainty["electricity"], "default")
+	sampled := IntensitySeries{Region: series.Region, Points: make([]IntensityPoint, len(series.Points))}
+	for i, point := range series.Points {
+		point.Intensity *= scale
+		sampled.Points[i] = point
+	}
+	return sampled, nil
+}
+
+func (m *monteCarloSource) SampleEstimate(name string, value float64, u Uncertainty) float64 {
+	return value * m.draw(name, u, "estimate")
+}
+
+func (m *monteCarloSource) draw(name string, u Uncertainty, source string) float64 {
+	if multiple, ok := m.draws[name]; ok {
+		return multiple
+	}
+	multiple := u.sample(m.rng)
+	m.draws[name] = multiple
+	if _, seen := m.inputs[name]; !seen {
+		m.inputs[name] = UncertainInput{Name: name, Uncertainty: u, Source: source}
+	}
+	return multiple
+}
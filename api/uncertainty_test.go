@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// rangedFactor serves a factor of 1 with a fixed uncertainty.
+type rangedFactor struct {
+	uncertainty Uncertainty
+}
+
+func (f rangedFactor) EmissionFactor(q FactorQuery) (EmissionFactor, error) {
+	u := f.uncertainty
+	return EmissionFactor{Activity: q.Activity, TransportMode: q.Mode, Factor: 1, Source: "test", Uncertainty: &u}, nil
+}
+
+func TestPropagateUncertaintyPercentiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		u          Uncertainty
+		p5, p95    float64
+		tolerance  float64
+		wantRating string
+	}{
+		// Percentiles of the distributions themselves, times 100 litres
+		{"uniform ±10%", Uncertainty{Distribution: DistributionUniform, Low: 0.9, High: 1.1}, 91, 109, 0.5, "good"},
+		{"normal 10%", Uncertainty{Distribution: DistributionNormal, StdDev: 0.1}, 83.55, 116.45, 1.5, "fair"},
+		{"lognormal GSD 1.5", Uncertainty{Distribution: DistributionLognormal, GSD: 1.5}, 51.32, 194.87, 5, "very_poor"},
+	}
+
+	req := CalculateRequest{Activity: "fuel", Amount: 100, Transport: "diesel"}
+	for _, tt := range tests {
+		result, err := propagateUncertainty(fuelCalculator{}, rangedFactor{tt.u}, req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if result.Samples != monteCarloSamples {
+			t.Errorf("%s: %d samples, want %d", tt.name, result.Samples, monteCarloSamples)
+		}
+		if math.Abs(result.P5-tt.p5) > tt.tolerance || math.Abs(result.P95-tt.p95) > tt.tolerance {
+			t.Errorf("%s: p5-p95 = %v-%v, want %v-%v", tt.name, result.P5, result.P95, tt.p5, tt.p95)
+		}
+		if result.DataQuality.Rating != tt.wantRating {
+			t.Errorf("%s: rating = %s, want %s", tt.name, result.DataQuality.Rating, tt.wantRating)
+		}
+
+		again, _ := propagateUncertainty(fuelCalculator{}, rangedFactor{tt.u}, req)
+		if again.P5 != result.P5 || again.P95 != result.P95 {
+			t.Errorf("%s: a repeated run reported %v-%v, then %v-%v", tt.name, result.P5, result.P95, again.P5, again.P95)
+		}
+	}
+}
+
+func TestSummarizeSamples(t *testing.T) {
+	ramp := make([]float64, 100)
+	for i := range ramp {
+		ramp[i] = float64(100 - i)
+	}
+	flat := []float64{10, 10, 10, 10}
+	published := map[string]UncertainInput{"factor:fuel/diesel/GB": {Name: "factor:fuel/diesel/GB", Source: "factor"}}
+	assumed := map[string]UncertainInput{"factor:fuel/diesel/WORLD": {Name: "factor:fuel/diesel/WORLD", Source: "default"}}
+
+	tests := []struct {
+		name          string
+		footprints    []float64
+		inputs        map[string]UncertainInput
+		mean, std     float64
+		p5, p95       float64
+		score         int
+		rating        string
+		relativeRange float64
+	}{
+		{"ramp", ramp, published, 50.5, 29.011, 5, 95, 5, "very_poor", 0.8911},
+		{"no spread", flat, published, 10, 0, 10, 10, 1, "very_good", 0},
+		{"no spread, assumed range", flat, assumed, 10, 0, 10, 10, 2, "good", 0},
+	}
+	for _, tt := range tests {
+		result := summarizeSamples(append([]float64(nil), tt.footprints...), tt.inputs)
+		if result.Mean != tt.mean || result.StdDev != tt.std || result.P5 != tt.p5 || result.P95 != tt.p95 {
+			t.Errorf("%s: mean %v, std %v, p5 %v, p95 %v; want %v, %v, %v, %v",
+				tt.name, result.Mean, result.StdDev, result.P5, result.P95, tt.mean, tt.std, tt.p5, tt.p95)
+		}
+		if result.DataQuality != (DataQuality{Score: tt.score, Rating: tt.rating, RelativeRange: tt.relativeRange}) {
+			t.Errorf("%s: data quality = %+v, want %d %s %v", tt.name, result.DataQuality, tt.score, tt.rating, tt.relativeRange)
+		}
+		if len(result.Inputs) != len(tt.inputs) {
+			t.Errorf("%s: %d inputs, want %d", tt.name, len(result.Inputs), len(tt.inputs))
+		}
+	}
+}
+
+func TestUncertaintyIsOptIn(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	req := CalculateRequest{Activity: "fuel", Amount: 100, Transport: "diesel"}
+
+	for _, want := range []bool{false, true} {
+		response, err := cs.calculateCarbonFootprint(req, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := response.Uncertainty != nil; got != want {
+			t.Errorf("withUncertainty %v: uncertainty reported = %v", want, got)
+		}
+	}
+}
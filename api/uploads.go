@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	maxUploadRows = 100000
+	// uploadChunkSize rows are calculated and stored together, and progress
+	// is reported after each chunk.
+	uploadChunkSize = 100
+	// uploadWorkers bounds how many jobs run at once; later jobs stay queued.
+	uploadWorkers = 2
+	uploadJobTTL  = 24 * time.Hour
+)
+
+const (
+	uploadFormatCSV  = "csv"
+	uploadFormatXLSX = "xlsx"
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+)
+
+type uploadFieldKind int
+
+const (
+	uploadText uploadFieldKind = iota
+	uploadNumber
+	uploadInteger
+	uploadBoolean
+	// uploadList cells hold comma-separated values
+	uploadList
+)
+
+// uploadFields are the CalculateRequest fields a spreadsheet column can map
+// to. Dates are expected as YYYY-MM-DD text.
+var uploadFields = map[string]uploadFieldKind{
+	"activity":          uploadText,
+	"weight":            uploadNumber,
+	"distance":          uploadNumber,
+	"from":              uploadText,
+	"to":                uploadText,
+	"transport":         uploadText,
+	"amount":            uploadNumber,
+	"unit":              uploadText,
+	"activity_date":     uploadText,
+	"passengers":        uploadInteger,
+	"cabin_class":       uploadText,
+	"round_trip":        uploadBoolean,
+	"radiative_forcing": uploadNumber,
+	"tags":              uploadList,
+	"scope":             uploadInteger,
+	"scope3_category":   uploadInteger,
+	"gwp":               uploadText,
+	"region":            uploadText,
+	"country":           uploadText,
+	"scope2_method":     uploadText,
+	"supplier_factor":   uploadNumber,
+}
+
+// UploadRowError reports a row that could not be calculated. Row is the
+// line or row number in the uploaded file.
+type UploadRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// UploadJob is a spreadsheet being calculated in the background.
+type UploadJob struct {
+	ID                   string            `json:"id"`
+	Status               string            `json:"status"`
+	Format               string            `json:"format"`
+	Filename             string            `json:"filename,omitempty"`
+	Mapping              map[string]string `json:"mapping"`
+	TotalRows            int               `json:"total_rows"`
+	ProcessedRows        int               `json:"processed_rows"`
+	Succeeded            int               `json:"succeeded"`
+	Failed               int               `json:"failed"`
+	TotalCarbonFootprint float64           `json:"total_carbon_footprint"`
+	Unit                 string            `json:"unit"`
+	Errors               []UploadRowError  `json:"errors,omitempty"`
+	Message              string            `json:"message,omitempty"`
+	CreatedAt            time.Time         `json:"created_at"`
+	StartedAt            *time.Time        `json:"started_at,omitempty"`
+	CompletedAt          *time.Time        `json:"completed_at,omitempty"`
+	ExpiresAt            *time.Time        `json:"expires_at,omitempty"`
+
+	owner     string
+	principal Principal
+	header    []string
+	rows      [][]string
+	lines     []int
+	result    []byte
+	// refund gives back the quota charged for failed rows
+	refund func(n int)
+}
+
+// uploadJobs tracks jobs in process memory. Jobs do not survive a restart
+// and can only be polled on the instance that accepted them.
+type uploadJobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*UploadJob
+	slots chan struct{}
+}
+
+func newUploadJobs() *uploadJobs {
+	return &uploadJobs{
+		jobs:  make(map[string]*UploadJob),
+		slots: make(chan struct{}, uploadWorkers),
+	}
+}
+
+func (u *uploadJobs) add(job *UploadJob) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range u.jobs {
+		if existing.ExpiresAt != nil && now.After(*existing.ExpiresAt) {
+			delete(u.jobs, id)
+		}
+	}
+	u.jobs[job.ID] = job
+}
+
+// get returns a copy of the job for owner, safe to serialize while the job
+// keeps running.
+func (u *uploadJobs) get(id, owner string) (UploadJob, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	job, ok := u.jobs[id]
+	if !ok || job.owner != owner || (job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt)) {
+		return UploadJob{}, false
+	}
+	snapshot := *job
+	snapshot.Errors = append([]UploadRowError(nil), job.Errors...)
+	return snapshot, true
+}
+
+func (u *uploadJobs) update(job *UploadJob, apply func(job *UploadJob)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	apply(job)
+}
+
+// CreateUpload handles POST /calculate/uploads. The spreadsheet is sent as a
+// multipart "file" (or the raw body) with an optional "mapping" of
+// CalculateRequest field to column header; without one, headers named after
+// the fields are used. The job is processed in the background.
+func (cs *CarbonService) CreateUpload(c *fiber.Ctx) error {
+	data := c.Body()
+	filename := ""
+	if header, err := c.FormFile("file"); err == nil {
+		file, err := header.Open()
+		if err != nil {
+			return validationResponse(c, errors.New("Unable to read uploaded file"))
+		}
+		defer file.Close()
+		if data, err = io.ReadAll(file); err != nil {
+			return validationResponse(c, errors.New("Unable to read uploaded file"))
+		}
+		filename = header.Filename
+	}
+	if len(data) == 0 {
+		return validationResponse(c, errors.New("Upload a CSV or XLSX file"))
+	}
+
+	format, err := uploadFormat(firstNonEmpty(c.FormValue("format"), c.Query("format")), filename, data)
+	if err != nil {
+		return validationResponse(c, err)
+	}
+	rows, lines, err := readUploadRows(format, data)
+	if err != nil {
+		return validationResponse(c, err)
+	}
+	if len(rows) < 2 {
+		return validationResponse(c, errors.New("The file has no data rows below the header"))
+	}
+	if len(rows)-1 > maxUploadRows {
+		return validationResponse(c, fmt.Errorf("The file exceeds the limit of %d rows", maxUploadRows))
+	}
+
+	var mapping map[string]string
+	if raw := firstNonEmpty(c.FormValue("mapping"), c.Query("mapping")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			return validationResponse(c, invalidField("mapping", "must be a JSON object of field to column"))
+		}
+	}
+	mapping, err = resolveUploadMapping(rows[0], mapping)
+	if err != nil {
+		return validationResponse(c, err)
+	}
+
+	// Every row is charged up front; rows that fail are refunded as they
+	// are processed
+	refund, err := cs.limiter.charge(c, len(rows)-1)
+	if err != nil {
+		return err
+	}
+
+	principal := principalFrom(c)
+	job := &UploadJob{
+		ID:        uuid.NewString(),
+		Status:    jobQueued,
+		Format:    format,
+		Filename:  filename,
+		Mapping:   mapping,
+		TotalRows: len(rows) - 1,
+		Unit:      "kg_co2e",
+		CreatedAt: time.Now().UTC(),
+		owner:     principal.Subject,
+		principal: principal,
+		refund:    refund,
+		header:    rows[0],
+		rows:      rows[1:],
+		lines:     lines[1:],
+	}
+	cs.uploads.add(job)
+	go cs.runUpload(job)
+
+	snapshot, _ := cs.uploads.get(job.ID, principal.Subject)
+	c.Set(fiber.HeaderLocation, "/api/v1/calculate/uploads/"+job.ID)
+	return c.Status(fiber.StatusAccepted).JSON(snapshot)
+}
+
+// GetUpload handles GET /calculate/uploads/:id, reporting progress and row
+// errors.
+func (cs *CarbonService) GetUpload(c *fiber.Ctx) error {
+	job, ok := cs.uploads.get(c.Params("id"), principalFrom(c).Subject)
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Upload job not found")
+	}
+	return c.JSON(job)
+}
+
+// GetUploadResult handles GET /calculate/uploads/:id/result, returning the
+// uploaded file with carbon_footprint and error columns appended.
+func (cs *CarbonService) GetUploadResult(c *fiber.Ctx) error {
+	job, ok := cs.uploads.get(c.Params("id"), principalFrom(c).Subject)
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Upload job not found")
+	}
+	if job.Status != jobCompleted {
+		return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("Upload job is %s, results are not ready", job.Status))
+	}
+
+	name := strings.TrimSuffix(job.Filename, filepath.Ext(job.Filename))
+	if name == "" {
+		name = "calculations"
+	}
+	contentType := "text/csv"
+	if job.Format == uploadFormatXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Attachment(name + "-results." + job.Format)
+	return c.Send(job.result)
+}
+
+func (cs *CarbonService) runUpload(job *UploadJob) {
+	cs.uploads.slots <- struct{}{}
+	defer func() { <-cs.uploads.slots }()
+
+	cs.uploads.update(job, func(job *UploadJob) {
+		now := time.Now().UTC()
+		job.Status = jobRunning
+		job.StartedAt = &now
+	})
+
+	footprints := make([]string, len(job.rows))
+	rowErrors := make([]string, len(job.rows))
+	var total float64
+	for start := 0; start < len(job.rows); start += uploadChunkSize {
+		end := min(start+uploadChunkSize, len(job.rows))
+
+		var items []BatchItem
+		var chunkErrors []UploadRowError
+		for i := start; i < end; i++ {
+			req, err := uploadRowRequest(job.header, job.rows[i], job.Mapping)
+			if err != nil {
+				rowErrors[i] = err.Error()
+				chunkErrors = append(chunkErrors, UploadRowError{Row: job.lines[i], Error: err.Error()})
+				continue
+			}
+			items = append(items, BatchItem{LineID: strconv.Itoa(i), CalculateRequest: req})
+		}
+
+		var records []CalculationRecord
+		succeeded := 0
+		// The result file has no room for uncertainty ranges
+		for n, result := range cs.calculateBatch(items, false) {
+			i, _ := strconv.Atoi(result.LineID)
+			if result.Result == nil {
+				rowErrors[i] = result.Error
+				chunkErrors = append(chunkErrors, UploadRowError{Row: job.lines[i], Error: result.Error})
+				continue
+			}
+			succeeded++
+			total += result.Result.CarbonFootprint
+			footprints[i] = strconv.FormatFloat(result.Result.CarbonFootprint, 'f', -1, 64)
+			records = append(records, newCalculationRecord(items[n].CalculateRequest, result.Result, job.principal))
+		}
+		if len(records) > 0 {
+			cs.outbox.Enqueue(records)
+		}
+		if len(chunkErrors) > 0 {
+			job.refund(len(chunkErrors))
+		}
+		sort.Slice(chunkErrors, func(a, b int) bool { return chunkErrors[a].Row < chunkErrors[b].Row })
+
+		processed := end
+		cs.uploads.update(job, func(job *UploadJob) {
+			job.ProcessedRows = processed
+			job.Succeeded += succeeded
+			job.Failed += len(chunkErrors)
+			job.TotalCarbonFootprint = roundTo(total, 3)
+			job.Errors = append(job.Errors, chunkErrors...)
+		})
+	}
+
+	result, err := writeUploadResult(job.Format, job.header, job.rows, footprints, rowErrors)
+	cs.uploads.update(job, func(job *UploadJob) {
+		now := time.Now().UTC()
+		expires := now.Add(uploadJobTTL)
+		job.CompletedAt = &now
+		job.ExpiresAt = &expires
+		job.header, job.rows, job.lines = nil, nil, nil
+		if err != nil {
+			log.Printf("Failed to write results of upload %s: %v", job.ID, err)
+			job.Status = jobFailed
+			job.Message = "Failed to write the result file"
+			return
+		}
+		job.Status = jobCompleted
+		job.result = result
+	})
+}
+
+// uploadFormat picks the file format from an explicit value, the file
+// extension or, failing both, the content: XLSX files are zip archives.
+func uploadFormat(explicit, filename string, data []byte) (string, error) {
+	format := strings.ToLower(explicit)
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	switch format {
+	case uploadFormatCSV, uploadFormatXLSX:
+		return format, nil
+	case "":
+		if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+			return uploadFormatXLSX, nil
+		}
+		return uploadFormatCSV, nil
+	}
+	return "", invalidField("format", "unsupported format %q, expected csv or xlsx", format)
+}
+
+// readUploadRows returns the rows of a CSV file or of the first sheet of a
+// workbook without blank rows, along with the line number of each.
+func readUploadRows(format string, data []byte) ([][]string, []int, error) {
+	var rows [][]string
+	var lines []int
+	keep := func(row []string, line int) {
+		if strings.TrimSpace(strings.Join(row, "")) != "" {
+			rows = append(rows, row)
+			lines = append(lines, line)
+		}
+	}
+
+	if format == uploadFormatXLSX {
+		workbook, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid XLSX file: %v", err)
+		}
+		defer workbook.Close()
+		sheets := workbook.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, nil, errors.New("The workbook has no sheets")
+		}
+		sheet, err := workbook.GetRows(sheets[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid XLSX file: %v", err)
+		}
+		for i, row := range sheet {
+			keep(row, i+1)
+		}
+		return rows, lines, nil
+	}
+
+	// Excel prefixes CSV exports with a byte order mark
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return rows, lines, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid CSV file: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		keep(row, line)
+	}
+}
+
+// resolveUploadMapping checks a field to column mapping against the header,
+// deriving one from the header names when none was given.
+func resolveUploadMapping(header []string, mapping map[string]string) (map[string]string, error) {
+	if len(mapping) == 0 {
+		mapping = make(map[string]string)
+		for _, column := range header {
+			field := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(column)), " ", "_")
+			if _, known := uploadFields[field]; known {
+				mapping[field] = column
+			}
+		}
+	}
+
+	for field, column := range mapping {
+		if _, known := uploadFields[field]; !known {
+			return nil, invalidField("mapping", "unknown field %q", field)
+		}
+		if uploadColumn(header, column) < 0 {
+			return nil, invalidField("mapping", "column %q for %s is not in the header", column, field)
+		}
+	}
+	if _, ok := mapping["activity"]; !ok {
+		return nil, invalidField("mapping", "an activity column is required")
+	}
+	return mapping, nil
+}
+
+func uploadColumn(header []string, column string) int {
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(column)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// uploadRowRequest converts a row into a calculation request.
+func uploadRowRequest(header, row []string, mapping map[string]string) (CalculateRequest, error) {
+	values := make(map[string]interface{}, len(mapping))
+	for field, column := range mapping {
+		var cell string
+		if i := uploadColumn(header, column); i < len(row) {
+			cell = strings.TrimSpace(row[i])
+		}
+		if cell == "" {
+			continue
+		}
+
+		switch uploadFields[field] {
+		case uploadNumber:
+			number, ok := parseDatasetNumber(cell)
+			if !ok {
+				return CalculateRequest{}, invalidField(field, "%q is not a number", cell)
+			}
+			values[field] = number
+		case uploadInteger:
+			number, err := strconv.Atoi(cell)
+			if err != nil {
+				return CalculateRequest{}, invalidField(field, "%q is not a whole number", cell)
+			}
+			values[field] = number
+		case uploadBoolean:
+			flag, err := strconv.ParseBool(strings.ToLower(cell))
+			if err != nil {
+				return CalculateRequest{}, invalidField(field, "%q is not true or false", cell)
+			}
+			values[field] = flag
+		case uploadList:
+			var items []string
+			for _, item := range strings.Split(cell, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			values[field] = items
+		default:
+			values[field] = cell
+		}
+	}
+
+	// Fields are decoded one at a time so an error names its column
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var req CalculateRequest
+	for _, field := range fields {
+		encoded, err := json.Marshal(map[string]interface{}{field: values[field]})
+		if err == nil {
+			err = json.Unmarshal(encoded, &req)
+		}
+		if err != nil {
+			return CalculateRequest{}, invalidField(field, "%v", err)
+		}
+	}
+	return req, nil
+}
+
+// writeUploadResult returns the uploaded rows with the footprint and error
+// of each appended, in the format they arrived in.
+func writeUploadResult(format string, header []string, rows [][]string, footprints, rowErrors []string) ([]byte, error) {
+	width := len(header)
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	line := func(row []string, footprint, rowError string) []string {
+		out := make([]string, width, width+2)
+		copy(out, row)
+		return append(out, footprint, rowError)
+	}
+
+	var buf bytes.Buffer
+	if format == uploadFormatXLSX {
+		workbook := excelize.NewFile()
+		defer workbook.Close()
+		sheet := workbook.GetSheetName(0)
+		write := func(n int, cells []string) error {
+			values := make([]interface{}, len(cells))
+			for i, cell := range cells {
+				values[i] = cell
+			}
+			// Keep the footprint numeric so it can be summed in Excel
+			if footprint, err := strconv.ParseFloat(cells[width], 64); err == nil {
+				values[width] = footprint
+			}
+			cellName, _ := excelize.CoordinatesToCellName(1, n)
+			return workbook.SetSheetRow(sheet, cellName, &values)
+		}
+
+		if err := write(1, line(header, "carbon_footprint", "error")); err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			if err := write(i+2, line(row, footprints[i], rowErrors[i])); err != nil {
+				return nil, err
+			}
+		}
+		if err := workbook.Write(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	writer := csv.NewWriter(&buf)
+	writer.Write(line(header, "carbon_footprint", "error"))
+	for i, row := range rows {
+		writer.Write(line(row, footprints[i], rowErrors[i]))
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
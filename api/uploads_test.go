@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUploadRowRequestErrors(t *testing.T) {
+	header := []string{"activity", "amount", "activity_date", "passengers", "round_trip"}
+	mapping, err := resolveUploadMapping(header, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		row   []string
+		field string
+	}{
+		{[]string{"fuel", "10", "2024-03-01", "", ""}, ""},
+		{[]string{"fuel", "ten", "2024-03-01", "", ""}, "amount"},
+		{[]string{"fuel", "10", "March 1st", "", ""}, "activity_date"},
+		{[]string{"flight", "", "", "two", ""}, "passengers"},
+		{[]string{"flight", "", "", "", "maybe"}, "round_trip"},
+	}
+	for _, tt := range tests {
+		_, err := uploadRowRequest(header, tt.row, mapping)
+		var validation *ValidationError
+		switch {
+		case tt.field == "" && err != nil:
+			t.Errorf("%v: unexpected error %v", tt.row, err)
+		case tt.field != "" && !errors.As(err, &validation):
+			t.Errorf("%v: error = %v, want a validation error", tt.row, err)
+		case tt.field != "" && validation.Field != tt.field:
+			t.Errorf("%v: field = %q, want %q", tt.row, validation.Field, tt.field)
+		}
+	}
+}
+
+func TestUploadRefundsFailedRows(t *testing.T) {
+	cs := NewCarbonService(NewMemoryStore(), nil)
+	rows, lines, err := readUploadRows(uploadFormatCSV, []byte(strings.Join([]string{
+		"activity,amount,transport,activity_date",
+		"fuel,10,diesel,2024-03-01",
+		"fuel,ten,diesel,2024-03-01",
+		"fuel,10,diesel,yesterday",
+		"fuel,-1,diesel,",
+		"fuel,5,gasoline,",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := resolveUploadMapping(rows[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refunded := 0
+	job := &UploadJob{
+		ID:      "test",
+		Mapping: mapping,
+		Format:  uploadFormatCSV,
+		header:  rows[0],
+		rows:    rows[1:],
+		lines:   lines[1:],
+		refund:  func(n int) { refunded += n },
+	}
+	cs.uploads.add(job)
+	cs.runUpload(job)
+
+	if job.Status != jobCompleted || job.Succeeded != 2 || job.Failed != 3 {
+		t.Fatalf("job = %s, %d succeeded, %d failed; want completed, 2 and 3", job.Status, job.Succeeded, job.Failed)
+	}
+	if refunded != 3 {
+		t.Errorf("refunded %d rows, want 3", refunded)
+	}
+}
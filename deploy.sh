@@ -19,7 +19,7 @@ echo "📦 Deploying to environment: $ENVIRONMENT"
 # Build the Lambda function
 echo "🔨 Building Lambda function..."
 cd api
-GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -ldflags="-s -w" -o main main.go database.go carbon_service.go
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -ldflags="-s -w" -o main .
 zip carbonapi.zip main
 echo "✅ Lambda function built successfully"
 cd ..